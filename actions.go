@@ -0,0 +1,83 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// actionResult is what -delete or -hardlink did to one duplicate.
+type actionResult struct {
+	what     string // "removed", "would link", and so on
+	path     string // the duplicate
+	original string // the file it's a duplicate of, which we kept
+	size     int64
+	sum      string // the digest we computed during the scan
+	err      error  // why we couldn't, nil if we did (or would)
+}
+
+// actions are the results of -delete or -hardlink so far, in the order
+// we acted.
+var actions []actionResult
+
+// act runs -delete on the clusters of the given originals, or -hardlink
+// on the given linkable ones, after confirming; questions go to prompts,
+// and what we did goes to w.
+func act(originals, linkable []string, prompts, w io.Writer) {
+	// one reader for all questions, so answers read ahead aren't lost
+	in := bufio.NewReader(os.Stdin)
+
+	if *deleting && confirm(prompts, "remove", originals, in) {
+		deleteDupes(originals, in, prompts, w)
+	}
+
+	if *hardlink && confirm(prompts, "hard link", linkable, in) {
+		hardlinkDupes(linkable, w)
+	}
+}
+
+// manifestOut is where -action-manifest goes, nil without it.
+var manifestOut io.Writer
+
+// openManifest makes every duplicate -delete or -hardlink acts on go to
+// a new CSV file with the given path, after a header row; see openOutput.
+func openManifest(path string) (func() error, error) {
+	closeManifest, err := redirect(&manifestOut, path)
+	if err != nil {
+		return nil, err
+	}
+	w := csv.NewWriter(manifestOut)
+	w.Write([]string{"hash", "size", "path", "original", "action"})
+	w.Flush()
+
+	return func() error {
+		err := closeManifest()
+		manifestOut = nil
+		return err
+	}, nil
+}
+
+// acted records what we did to the duplicate at the given path in the
+// cluster of the given original, say "removed" or "would link", or that we
+// failed with the given error. Things we actually did (or would do, with
+// -dry-run) also go to the manifest.
+func acted(what, path, original string, err error) {
+	c := final[original]
+	actions = append(actions, actionResult{what: what, path: path, original: original, size: c.size, sum: c.sum, err: err})
+	if manifestOut == nil || err != nil {
+		return
+	}
+	w := csv.NewWriter(manifestOut)
+	w.Write([]string{c.sum, strconv.FormatInt(c.size, 10), path, original, what})
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fmt.Fprintf(errOut, "warning: can't write action manifest (%v)\n", err)
+	}
+}
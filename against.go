@@ -0,0 +1,35 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+// restrictAgainst keeps only the files that already exist in the
+// reference directory of -against, which is the first of the given
+// roots. We walk it first, so its files become the originals; clusters
+// without an original in it only have duplicates among the new files,
+// and duplicates within it are old news, so we forget both.
+func restrictAgainst(roots []string) {
+	for k, c := range final {
+		dupes -= counter(len(c.duplicates))
+		wasted -= c.waste()
+		if rootOf(k, roots) != 0 {
+			delete(final, k)
+			continue
+		}
+
+		var fresh []string
+		for _, d := range c.duplicates {
+			if rootOf(d, roots) != 0 {
+				fresh = append(fresh, d)
+			}
+		}
+		if len(fresh) == 0 {
+			delete(final, k)
+			continue
+		}
+		c.duplicates = fresh
+		dupes += counter(len(c.duplicates))
+		wasted += c.waste()
+	}
+}
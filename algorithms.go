@@ -0,0 +1,54 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"sort"
+	"strings"
+)
+
+const (
+	hashDefault = "sha256"
+)
+
+// hashAlgorithms maps the names -hash accepts to hash constructors.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"md5":    md5.New,
+	"crc32":  func() hash.Hash { return crc32.NewIEEE() },
+	"xxhash": func() hash.Hash { return newXXHash() },
+}
+
+// newHash makes a hasher for the algorithm selected with -hash.
+var newHash = sha256.New
+
+// hashNames lists the names -hash accepts, sorted.
+func hashNames() string {
+	var names []string
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// selectHash makes newHash use the algorithm with the given name.
+func selectHash(name string) error {
+	h, ok := hashAlgorithms[name]
+	if !ok {
+		return fmt.Errorf("unknown hash algorithm %q for -hash (try %s)", name, hashNames())
+	}
+	newHash = h
+	return nil
+}
@@ -0,0 +1,37 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// commonAncestor returns the longest directory path shared by all the
+// given paths; it's empty if they don't share anything.
+func commonAncestor(paths []string) string {
+	sep := string(filepath.Separator)
+
+	var common []string
+	for i, p := range paths {
+		parts := strings.Split(filepath.Dir(filepath.Clean(p)), sep)
+		if i == 0 {
+			common = parts
+			continue
+		}
+		n := 0
+		for n < len(common) && n < len(parts) && common[n] == parts[n] {
+			n++
+		}
+		common = common[:n]
+	}
+
+	ancestor := strings.Join(common, sep)
+	if ancestor == "" && len(common) > 0 {
+		// all paths were absolute but shared nothing else
+		return sep
+	}
+	return ancestor
+}
@@ -0,0 +1,59 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// isUnder checks whether the given path is the given directory or
+// somewhere below it. Both are made absolute first so it doesn't matter
+// how they were spelled on the command line.
+func isUnder(path, dir string) bool {
+	p, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	d, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	if p == d {
+		return true
+	}
+	if !strings.HasSuffix(d, string(filepath.Separator)) {
+		d += string(filepath.Separator)
+	}
+	return strings.HasPrefix(p, d)
+}
+
+// autoSafe checks whether the cluster with the given original is safe to
+// clean up automatically: every duplicate has to be under the scratch
+// directory while the original must not be.
+func autoSafe(original string, scratch string) bool {
+	if isUnder(original, scratch) {
+		return false
+	}
+	for _, d := range final[original].duplicates {
+		if !isUnder(d, scratch) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitAutoSafe splits the given clusters into those that are safe to
+// clean up automatically and those that need review, keeping their order.
+func splitAutoSafe(originals []string, scratch string) (safe, review []string) {
+	for _, k := range originals {
+		if autoSafe(k, scratch) {
+			safe = append(safe, k)
+		} else {
+			review = append(review, k)
+		}
+	}
+	return safe, review
+}
@@ -0,0 +1,172 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// cacheEntry is what we remember about a file between runs.
+type cacheEntry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"` // in nanoseconds since the epoch
+	Sum     string `json:"sum"`
+}
+
+// digestCache maps from absolute paths to digests calculated by earlier
+// runs; a digest is only good as long as size and modification time of
+// the file haven't changed. The workers use it concurrently.
+type digestCache struct {
+	mu      sync.Mutex
+	Mode    string                `json:"mode"`
+	Entries map[string]cacheEntry `json:"entries"`
+	seen    map[string]bool       // keys of the files walked this time
+}
+
+// cache is the digest cache for the current scan, nil without -cache.
+var cache *digestCache
+
+// cacheMode describes the options that change what a digest means;
+// digests from a run with a different mode are useless. Extended
+// attributes are never part of a cached digest, so -with-xattr isn't
+// either.
+func cacheMode() string {
+	return fmt.Sprintf("%s fold-case=%v ignore-metadata=%v", *hashName, *foldCase, *ignoreMeta)
+}
+
+// newCache returns an empty cache for the current options.
+func newCache() *digestCache {
+	return &digestCache{Mode: cacheMode(), Entries: make(map[string]cacheEntry), seen: make(map[string]bool)}
+}
+
+// loadCache reads the cache from the file with the given path. A missing
+// file, or one written with a different mode, is just an empty cache.
+func loadCache(path string) (*digestCache, error) {
+	c := newCache()
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var old digestCache
+	if err := json.NewDecoder(file).Decode(&old); err != nil {
+		return nil, err
+	}
+	if old.Mode == c.Mode && old.Entries != nil {
+		c.Entries = old.Entries
+	}
+	return c, nil
+}
+
+// save writes the cache to the file with the given path, forgetting the
+// files we didn't walk this time; otherwise files that are long gone
+// would stay in the cache forever. We write to a temporary file first so
+// a crash can't leave a broken cache behind.
+func (c *digestCache) save(path string) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	for key := range c.Entries {
+		if !c.seen[key] {
+			delete(c.Entries, key)
+		}
+	}
+	err = json.NewEncoder(file).Encode(c)
+	c.mu.Unlock()
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// cacheKey returns the key for the file with the given path.
+func cacheKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// stamp returns the key and the entry (without digest) for the file
+// with the given path.
+func stamp(path string) (string, cacheEntry, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", cacheEntry{}, err
+	}
+	return cacheKey(path), cacheEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano()}, nil
+}
+
+// saw remembers that we walked the file with the given path, so save
+// keeps its entry.
+func (c *digestCache) saw(path string) {
+	key := cacheKey(path)
+	c.mu.Lock()
+	c.seen[key] = true
+	c.mu.Unlock()
+}
+
+// lookup returns the digest we remembered for the file with the given
+// key and stamp, if it hasn't changed since.
+func (c *digestCache) lookup(key string, now cacheEntry) (string, bool) {
+	c.mu.Lock()
+	old, ok := c.Entries[key]
+	c.mu.Unlock()
+	if ok && old.Size == now.Size && old.ModTime == now.ModTime {
+		return old.Sum, true
+	}
+	return "", false
+}
+
+// cachedSum returns the digest we remembered for the file with the given
+// path, if it hasn't changed since, so we don't even have to look at its
+// prefix.
+func (c *digestCache) cachedSum(path string) (string, bool) {
+	key, now, err := stamp(path)
+	if err != nil {
+		return "", false
+	}
+	return c.lookup(key, now)
+}
+
+// cachedDigest is digest for files we have a cache entry for: if the file
+// hasn't changed, we return the digest we remembered; otherwise we
+// calculate it and remember it for next time.
+func (c *digestCache) cachedDigest(path string, calculate func(string) (string, error)) (string, error) {
+	key, now, err := stamp(path)
+	if err != nil {
+		return "", err
+	}
+	if sum, ok := c.lookup(key, now); ok {
+		return sum, nil
+	}
+
+	sum, err := calculate(path)
+	if err != nil {
+		return "", err
+	}
+	now.Sum = sum
+	c.mu.Lock()
+	c.Entries[key] = now
+	c.mu.Unlock()
+	return sum, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// Large files whose digests are in the cache aren't read at all, not even
+// their prefix, and files we didn't come across are dropped from it.
+func TestCache(t *testing.T) {
+	capture(t)
+	big := strings.Repeat("x", 2048)
+	dir := writeFiles(t, map[string]string{"a": big, "b": big, "c": "small"})
+	file := filepath.Join(t.TempDir(), "cache")
+	if err := setFlags(t, map[string]string{"cache": file, "prefix": "1024"}); err != nil {
+		t.Fatal(err)
+	}
+
+	reset()
+	run([]string{dir})
+	if dupes != 1 || atomic.LoadInt64(&hashedBytes) == 0 {
+		t.Fatalf("got %v duplicates hashing %d bytes, want 1 hashing some", dupes, hashedBytes)
+	}
+
+	// pretend there used to be another file
+	c, err := loadCache(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone := filepath.Join(dir, "gone")
+	c.Entries[gone] = cacheEntry{Size: 1, Sum: "feed"}
+	for key := range c.Entries {
+		c.seen[key] = true
+	}
+	if err := c.save(file); err != nil {
+		t.Fatal(err)
+	}
+
+	reset()
+	run([]string{dir})
+	if dupes != 1 || atomic.LoadInt64(&hashedBytes) != 0 {
+		t.Errorf("got %v duplicates hashing %d bytes, want 1 hashing none", dupes, hashedBytes)
+	}
+	c, err = loadCache(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Entries[gone]; ok || len(c.Entries) != 2 {
+		t.Errorf("got cache entries %v, want a and b only", c.Entries)
+	}
+}
+
+// A cache entry for a file whose size collides with one that isn't in the
+// cache doesn't keep the other one from being hashed all the way.
+func TestCacheMixedWithPrefixes(t *testing.T) {
+	capture(t)
+	big := strings.Repeat("x", 2048)
+	dir := writeFiles(t, map[string]string{"a": big, "b": big})
+	file := filepath.Join(t.TempDir(), "cache")
+	if err := setFlags(t, map[string]string{"cache": file, "prefix": "1024"}); err != nil {
+		t.Fatal(err)
+	}
+	reset()
+	run([]string{dir})
+
+	// forget b but keep a
+	c, err := loadCache(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(c.Entries, filepath.Join(dir, "b"))
+	c.seen = map[string]bool{filepath.Join(dir, "a"): true}
+	if err := c.save(file); err != nil {
+		t.Fatal(err)
+	}
+
+	reset()
+	run([]string{dir})
+	if dupes != 1 {
+		t.Errorf("got %v duplicates, want 1", dupes)
+	}
+}
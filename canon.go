@@ -0,0 +1,148 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+var (
+	foldPaths bool            // are paths case-insensitive?
+	seenPaths map[string]bool // canonical paths we've walked (only if roots overlap)
+)
+
+// canonical turns the given path into a form that's the same no matter
+// how it was spelled: absolute, clean, and lower case if the filesystem
+// is case-insensitive.
+func canonical(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if foldPaths {
+		path = strings.ToLower(path)
+	}
+	return path
+}
+
+// caseInsensitive tries to find out whether the filesystem the given path
+// lives on ignores case by looking it up with the case of its last element
+// swapped. If that element has no letters, we can't tell and say no.
+func caseInsensitive(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	dir, base := filepath.Split(abs)
+	swapped := strings.Map(func(r rune) rune {
+		if unicode.IsUpper(r) {
+			return unicode.ToLower(r)
+		}
+		return unicode.ToUpper(r)
+	}, base)
+	if swapped == base {
+		return false
+	}
+
+	a, err := os.Stat(abs)
+	if err != nil {
+		return false
+	}
+	b, err := os.Stat(filepath.Join(dir, swapped))
+	if err != nil {
+		return false
+	}
+	return os.SameFile(a, b)
+}
+
+// rootsOverlap checks whether any two of the given roots overlap, so that
+// we could reach the same file twice.
+func rootsOverlap(roots []string) bool {
+	for i, a := range roots {
+		for j, b := range roots {
+			if i != j && isUnderCanonical(canonical(a), canonical(b)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isUnderCanonical is isUnder for paths that are already canonical.
+func isUnderCanonical(path, dir string) bool {
+	if path == dir {
+		return true
+	}
+	if !strings.HasSuffix(dir, string(filepath.Separator)) {
+		dir += string(filepath.Separator)
+	}
+	return strings.HasPrefix(path, dir)
+}
+
+// sameFileUnder checks whether the given path is the given directory, or
+// below it, by asking the operating system rather than by comparing
+// names; it returns the spelling of the ancestor that matched.
+func sameFileUnder(path, dir string) (string, bool) {
+	di, err := os.Stat(dir)
+	if err != nil {
+		return "", false
+	}
+	for p := path; ; p = filepath.Dir(p) {
+		if pi, err := os.Stat(p); err == nil && os.SameFile(pi, di) {
+			return p, true
+		}
+		if filepath.Dir(p) == p {
+			return "", false
+		}
+	}
+}
+
+// setupPaths decides how to canonicalize paths for the given roots and
+// whether we have to remember them all to avoid walking anything twice.
+func setupPaths(roots []string) {
+	foldPaths = *caseFold
+	for _, r := range roots {
+		if caseInsensitive(r) {
+			foldPaths = true
+		}
+	}
+
+	// caseInsensitive can't tell for roots without letters in their last
+	// element, like C:\ and c:\ on Windows; if two roots only differ in
+	// case but the operating system says they are the same, it's a
+	// case-insensitive filesystem after all
+	for i, a := range roots {
+		for j, b := range roots {
+			if i == j || foldPaths {
+				continue
+			}
+			ca, cb := canonical(a), canonical(b)
+			if p, ok := sameFileUnder(ca, cb); ok && p != cb && strings.EqualFold(p, cb) {
+				foldPaths = true
+			}
+		}
+	}
+
+	seenPaths = nil
+	if rootsOverlap(roots) {
+		seenPaths = make(map[string]bool)
+	}
+}
+
+// seenBefore checks whether we have walked the given path before, under
+// another name maybe; only necessary when roots overlap.
+func seenBefore(path string) bool {
+	if seenPaths == nil {
+		return false
+	}
+	c := canonical(path)
+	if seenPaths[c] {
+		return true
+	}
+	seenPaths[c] = true
+	return false
+}
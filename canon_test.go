@@ -0,0 +1,60 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// With -fs-case-insensitive the same file spelled two different ways is
+// only walked once.
+func TestSeenBeforeIgnoresCase(t *testing.T) {
+	if err := setFlags(t, map[string]string{"fs-case-insensitive": "true"}); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	setupPaths([]string{dir, filepath.Join(dir, "sub")})
+	t.Cleanup(func() { foldPaths, seenPaths = false, nil })
+
+	if seenBefore(filepath.Join(dir, "Sub", "File.txt")) {
+		t.Fatal("seen before the first time")
+	}
+	for _, path := range []string{
+		filepath.Join(dir, "sub", "file.txt"),
+		filepath.Join(dir, "SUB", ".", "FILE.TXT"),
+		filepath.Join(dir, "other", "..", "sub", "File.txt"),
+	} {
+		if !seenBefore(path) {
+			t.Errorf("%s not seen before", path)
+		}
+	}
+}
+
+// Roots that overlap only because they are spelled differently don't
+// turn a file into its own duplicate.
+func TestOverlappingRootsSpelledDifferently(t *testing.T) {
+	capture(t)
+	dir := writeFiles(t, map[string]string{"sub/a": "same", "sub/b": "same"})
+
+	reset()
+	run([]string{filepath.Join(dir, "sub"), dir + string(filepath.Separator) + "." + string(filepath.Separator)})
+	if files != 2 || dupes != 1 {
+		t.Errorf("got %v files and %v duplicates, want 2 and 1", files, dupes)
+	}
+}
+
+// Overlapping roots, even the same root twice, never make a file a
+// duplicate of itself.
+func TestOverlappingRoots(t *testing.T) {
+	capture(t)
+	dir := writeFiles(t, map[string]string{"a": "one", "sub/b": "two", "sub/deeper/c": "six"})
+
+	reset()
+	run([]string{dir, filepath.Join(dir, "sub"), dir, filepath.Join(dir, "sub", "deeper")})
+	if files != 3 || dupes != 0 || len(final) != 0 {
+		t.Errorf("got %v files and %v duplicates in clusters %v, want 3 and none", files, dupes, originals())
+	}
+}
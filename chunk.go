@@ -0,0 +1,88 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"io"
+)
+
+// Chunk sizes for content-defined chunking; the boundary mask gives us
+// chunks of about 8 KB on average.
+const (
+	chunkMin  = 2 * 1024
+	chunkMax  = 64 * 1024
+	chunkMask = 1<<13 - 1
+)
+
+var (
+	chunks       = make(map[[sha1.Size]byte]int) // maps from chunk digests to number of occurrences
+	chunkCount   counter                         // number of chunks examined
+	chunkShared  counter                         // number of chunks we had seen before
+	chunkSavings bytesize                        // space (in bytes) occupied by chunks we had seen before
+)
+
+// gear is the table of "random" values for the rolling hash; we fill it
+// with splitmix64 so boundaries are the same on every run.
+var gear = func() (g [256]uint64) {
+	x := uint64(0x9e3779b97f4a7c15)
+	for i := range g {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		g[i] = z ^ (z >> 31)
+	}
+	return
+}()
+
+// chunkFile splits the file with the given path into content-defined
+// chunks and records each of them. A chunk ends wherever the rolling
+// "gear" hash over the last few bytes hits the boundary mask, so an
+// insertion early in a file only changes the chunks around it instead
+// of shifting all boundaries after it.
+func chunkFile(path string) error {
+	file, err := openRegular(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	chunk := make([]byte, 0, chunkMax)
+	var h uint64
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		chunk = append(chunk, b)
+		h = h<<1 + gear[b]
+		if len(chunk) >= chunkMin && h&chunkMask == 0 || len(chunk) >= chunkMax {
+			recordChunk(chunk)
+			chunk = chunk[:0]
+			h = 0
+		}
+	}
+	if len(chunk) > 0 {
+		recordChunk(chunk)
+	}
+	return nil
+}
+
+// recordChunk remembers the given chunk and updates the statistics.
+func recordChunk(chunk []byte) {
+	sum := sha1.Sum(chunk)
+	chunkCount++
+	if chunks[sum] > 0 {
+		chunkShared++
+		chunkSavings += bytesize(len(chunk))
+	}
+	chunks[sum]++
+}
@@ -0,0 +1,103 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+)
+
+// textSniffLen is how much of a file we look at to decide whether it's
+// text; git uses the same heuristic.
+const textSniffLen = 8000
+
+// openContent opens the file with the given path for reading its contents
+// as far as comparisons are concerned; with -ignore-metadata media files
+// are read without their metadata; with -fold-case text files are read
+// with all ASCII letters in lower case.
+func openContent(path string) (io.ReadCloser, error) {
+	file, err := openRegular(path)
+	if err != nil {
+		return nil, err
+	}
+	if *ignoreMeta {
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		// isMedia already warned about files that only look like
+		// media, we read all of those
+		payload, ok, err := mediaPayload(file, info.Size())
+		if ok && err == nil {
+			return readCloser{payload, file}, nil
+		}
+	}
+	if !*foldCase {
+		return file, nil
+	}
+
+	text, err := isText(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !text {
+		return file, nil
+	}
+	return foldReader{file}, nil
+}
+
+// readCloser reads from one thing but closes another.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// isText checks whether the given file looks like text, meaning there's
+// no NUL byte near its beginning. It rewinds the file when done.
+func isText(file *os.File) (bool, error) {
+	buf := make([]byte, textSniffLen)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	return bytes.IndexByte(buf[:n], 0) < 0, nil
+}
+
+// foldReader lowercases ASCII letters as they are read. We deliberately
+// leave other letters alone: Unicode case folding can change the length
+// of the text, and all our comparisons rely on equal sizes.
+type foldReader struct {
+	io.ReadCloser
+}
+
+func (r foldReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	for i, c := range p[:n] {
+		if 'A' <= c && c <= 'Z' {
+			p[i] = c + 'a' - 'A'
+		}
+	}
+	return n, err
+}
+
+// contentMatches checks whether the first -content-limit bytes of the file
+// with the given path match the -content-match regular expression.
+func contentMatches(path string) (bool, error) {
+	file, err := openRegular(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(io.LimitReader(file, *grepLimit))
+	return contentRegexp.MatchReader(r), nil
+}
@@ -0,0 +1,36 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// -fold-case makes text files that only differ in case duplicates, but
+// leaves binary files alone.
+func TestFoldCase(t *testing.T) {
+	capture(t)
+	dir := writeFiles(t, map[string]string{
+		"text/a": "Hello, World!\n", "text/b": "hello, world!\n",
+		"bin/a": "Hello\x00", "bin/b": "hello\x00",
+	})
+
+	reset()
+	run([]string{dir})
+	if dupes != 0 {
+		t.Errorf("without -fold-case got %v duplicates, want none", dupes)
+	}
+
+	if err := setFlags(t, map[string]string{"fold-case": "true"}); err != nil {
+		t.Fatal(err)
+	}
+	reset()
+	run([]string{dir})
+	c, ok := final[filepath.Join(dir, "text", "a")]
+	if dupes != 1 || !ok || c.duplicates[0] != filepath.Join(dir, "text", "b") {
+		t.Errorf("with -fold-case got %v duplicates in clusters %v, want text/b as a duplicate of text/a", dupes, originals())
+	}
+}
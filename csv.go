@@ -0,0 +1,29 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"encoding/csv"
+	"strconv"
+)
+
+// printCSV prints the clusters with the given originals as CSV, one row
+// per file; clusters are numbered from 1 in the order given.
+func printCSV(originals []string) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"hash", "size", "cluster", "role", "path"})
+	for i, k := range originals {
+		c := final[k]
+		hash := c.sum
+		size := strconv.FormatInt(c.size, 10)
+		cluster := strconv.Itoa(i + 1)
+		w.Write([]string{hash, size, cluster, "original", k})
+		for _, v := range c.duplicates {
+			w.Write([]string{hash, size, cluster, "duplicate", v})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
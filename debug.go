@@ -0,0 +1,36 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// dumpState writes our internal maps to the file with the given path as
+// JSON; it's a debugging aid for when an expected duplicate isn't found.
+func dumpState(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	state := struct {
+		Sizes  map[int64]string    `json:"sizes"`
+		Hashes map[string]string   `json:"hashes"`
+		Final  map[string][]string `json:"final"`
+	}{sizes, hashes, make(map[string][]string)}
+	for k, c := range final {
+		state.Final[k] = c.duplicates
+	}
+
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(state); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
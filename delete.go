@@ -0,0 +1,105 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// confirm asks on prompts once whether to go ahead and do what the given
+// verb says to all duplicates in the clusters of the given originals,
+// showing how many files and how much space that affects. Anything but
+// "y" or "yes" means no, and so does not being able to read an answer.
+// With -yes or -dry-run we don't ask.
+func confirm(prompts io.Writer, verb string, originals []string, in *bufio.Reader) bool {
+	if *yes || *dryRun {
+		return true
+	}
+
+	var affected counter
+	var reclaimed bytesize
+	for _, k := range originals {
+		affected += counter(len(final[k].duplicates))
+		reclaimed += final[k].waste()
+	}
+	if affected == 0 {
+		return true
+	}
+
+	fmt.Fprintf(prompts, "about to %s %v files reclaiming %v, proceed? [y/N] ", verb, affected, reclaimed)
+	answer, err := in.ReadString('\n')
+	if err != nil {
+		fmt.Fprintln(prompts)
+		fmt.Fprintf(errOut, "warning: not going ahead without an answer, use -yes to skip this question (%v)\n", err)
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// deleteDupes asks, for each duplicate in the clusters of the given
+// originals, whether to remove it: y(es), n(o), a(ll) for yes to all the
+// rest, or q(uit); the questions go to prompts. Originals are never
+// removed. If we can't read an answer we stop right there, so nothing is
+// removed without a "y" or "a". With -dry-run we don't ask at all and only
+// say what we would remove. What we did goes to w.
+func deleteDupes(originals []string, in io.Reader, prompts, w io.Writer) {
+	var removed counter
+	var reclaimed bytesize
+
+	r := bufio.NewReader(in)
+	all := *dryRun
+	what := "removed"
+	if *dryRun {
+		what = "would remove"
+	}
+loop:
+	for _, k := range originals {
+		size := bytesize(final[k].size)
+		for _, d := range final[k].duplicates {
+			if !all {
+				fmt.Fprintf(prompts, "delete %s (%v, duplicate of %s)? [y/n/a/q] ", d, size, k)
+				answer, err := r.ReadString('\n')
+				if err != nil {
+					fmt.Fprintln(prompts)
+					fmt.Fprintf(errOut, "warning: stopped deleting (%v)\n", err)
+					break loop
+				}
+				switch strings.ToLower(strings.TrimSpace(answer)) {
+				case "y":
+				case "a":
+					all = true
+				case "q":
+					break loop
+				default:
+					continue
+				}
+			}
+			if *dryRun {
+				fmt.Fprintf(w, "would remove %s (%v)\n", d, size)
+			} else if err := os.Remove(d); err != nil {
+				fmt.Fprintf(errOut, "warning: can't remove %s (%v)\n", d, err)
+				acted(what, d, k, err)
+				continue
+			}
+			acted(what, d, k, nil)
+			removed++
+			reclaimed += size
+		}
+	}
+
+	if *dryRun {
+		fmt.Fprintf(w, "%v files would be removed, %v would be reclaimed\n", removed, reclaimed)
+		return
+	}
+	fmt.Fprintf(w, "%v files removed, %v reclaimed\n", removed, reclaimed)
+}
@@ -0,0 +1,61 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Outputs that are done once they printed their results would silently
+// skip -delete and -hardlink, so they can't be combined.
+func TestActionsNeedPlainOutput(t *testing.T) {
+	for _, action := range []string{"delete", "hardlink"} {
+		for _, output := range []string{"csv", "0", "list-dupes", "list-originals", "stats-json"} {
+			t.Run(action+"+"+output, func(t *testing.T) {
+				if err := setFlags(t, map[string]string{action: "true", output: "true", "yes": "true"}); err == nil {
+					t.Errorf("-%s with -%s accepted", action, output)
+				}
+			})
+		}
+	}
+}
+
+// The manifest lists every duplicate acted on with the digest from the
+// scan; with -dry-run nothing is touched.
+func TestActionManifestDryRun(t *testing.T) {
+	capture(t)
+	dir := writeFiles(t, map[string]string{"a": "same", "b": "same", "c": "other"})
+	path := filepath.Join(t.TempDir(), "manifest.csv")
+	if err := setFlags(t, map[string]string{"delete": "true", "dry-run": "true", "action-manifest": path}); err != nil {
+		t.Fatal(err)
+	}
+	closeManifest, err := openManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reset()
+	run([]string{dir})
+	if err := closeManifest(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte("same")))
+	want := "hash,size,path,original,action\n" +
+		sum + ",4," + filepath.Join(dir, "b") + "," + filepath.Join(dir, "a") + ",would remove\n"
+	if string(data) != want {
+		t.Errorf("got manifest\n%s\nwant\n%s", data, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b")); err != nil {
+		t.Errorf("-dry-run removed a file: %v", err)
+	}
+}
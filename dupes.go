@@ -8,186 +8,979 @@
 //
 //	dupes path1 path2 ...
 //
+// A path can have a depth limit as in photos=3; dupes then won't go
+// more than three directories deep below photos.
+//
+// If paths overlap, as in dupes ~ ~/Photos, dupes makes sure to examine
+// each file only once. The -fs-case-insensitive option tells dupes that
+// paths differing only in case are the same; usually it finds that out
+// by itself.
+//
 // Dupes will process each path. Directories will be walked
 // recursively, regular files will be checked against all
 // others. Dupes will print clusters of paths, separated
 // by an empty line, for each duplicate it finds. Dupes will
 // also print statistics about duplicates at the end.
 //
+// The first path in each cluster is the original, the others are its
+// duplicates. Since paths are processed in the order given, originals
+// always come from the earliest path that has a copy. Duplicates are
+// listed in sorted order.
+//
+// Files and directories dupes isn't allowed to read are skipped with a
+// warning and counted in the statistics.
+//
+// Dupes exits with status 0 if it found no duplicates, 1 if it found
+// some, and 2 if something went wrong.
+//
+// Pressing Ctrl-C stops the walk; dupes then reports what it found so
+// far and exits with status 2. Pressing it again quits right away.
+//
+// Files that are hard links to the original of a cluster (or to one of
+// its duplicates) are not duplicates since they don't waste any space;
+// they are only counted in the statistics.
+//
+// Each cluster starts with a line saying how many copies there are and
+// how much space they waste; the -no-stats option leaves those out, as
+// well as the statistics at the end.
+//
+// The -v option logs each file dupes hashes, and each file it skips
+// along with the reason, on stderr.
+//
+// The -q option prints only the statistics, not the clusters.
+//
+// The -count-only option also prints only the statistics, but doesn't
+// even keep the clusters in memory; options that need them can't be used
+// with it.
+//
+// The -si option prints sizes in powers of 1000 (kB, MB, GB, ...), like
+// disk vendors do, instead of powers of 1024.
+//
+// The -sort option orders clusters by the path of their original (the
+// default), by the space they waste, or by their number of duplicates.
+//
+// The -keep option decides which file of a cluster is its original, the
+// one -delete and -hardlink leave alone: the first one found (the
+// default), the oldest or newest by modification time, or the one with the
+// shortest path. Ties go to the one found first.
+//
+// The -min-cluster option only reports clusters with at least the given
+// number of files, original included; the statistics only count those.
+//
+// The -min-count option also reports, in the statistics, how much space
+// is wasted in clusters with at least the given number of files; all
+// clusters are still reported.
+//
+// The -o option writes the results to the given file instead of
+// stdout; warnings still go to stderr.
+//
+// The -log option appends warnings and errors to the given file instead
+// of writing them to stderr, each line starting with a timestamp.
+//
 // The -p option uses a "paranoid" byte-by-byte file comparison
-// instead of SHA1 digests to identify duplicates.
+// instead of hash digests to identify duplicates. Files with the same
+// digest that turn out to differ are reported on stderr, or in the file
+// given with -collisions, along with where they differ.
+//
+// The -hash option selects the hash algorithm used for digests: sha1,
+// sha256, sha512, md5, crc32, or xxhash; it defaults to sha256. Since
+// neither crc32 nor xxhash is a cryptographic hash, choosing one of them
+// turns on -p as well unless -p=false is given.
+//
+// The -j option sets how many files dupes hashes concurrently; it
+// defaults to the number of CPUs.
+//
+// The -mmap option makes -p compare files by mapping them into memory
+// instead of reading them; this only works on Unix, elsewhere (or if a
+// file can't be mapped) dupes reads them as usual.
+//
+// The -bufsize option sets how many bytes dupes reads from a file at a
+// time, for hashing as well as for comparing byte-by-byte; it defaults
+// to 1 MB.
+//
+// The -prefix option sets how many bytes of a large file dupes hashes
+// first; only files whose prefixes match are hashed all the way. It
+// defaults to 64 KB, 0 turns this off.
 //
 // The -s option sets the minimum file size you care about;
 // if defaults to 1 so empty files are ignored.
 //
+// The -include-empty option considers empty files anyway; they all end
+// up in one cluster without being read.
+//
+// The -S option sets the maximum file size you care about; it
+// defaults to 0 which means there's no maximum.
+//
 // The -g option sets a globbing pattern for the file names
 // you care about; it defaults to * which matches all file
-// names.
+// names. Several patterns can be separated by commas.
+//
+// The -i option ignores case when matching file names against the -g
+// patterns.
+//
+// The -types option only considers files in the given categories, as
+// told by their extensions: image, video, audio, document, or archive.
+// Several categories can be separated by commas.
+//
+// The -regex option sets a regular expression for the file names you
+// care about instead; with -regexpath it's matched against the path
+// relative to the directory given instead.
+//
+// The -exclude option sets a globbing pattern for file and directory
+// names to skip; directories that match aren't walked at all. It can be
+// given more than once.
+//
+// The -ignore-from option reads patterns to skip from the given file, one
+// per line, in a simplified .gitignore syntax: patterns with a slash are
+// matched against the path relative to the directory given, a trailing
+// slash only matches directories, and lines starting with # are ignored.
+//
+// The -no-hidden option skips files and directories whose names start
+// with a dot.
+//
+// The -newer-than-file and -older-than-file options only consider files
+// modified after (or before) the given reference file was.
+//
+// The -newer-than and -older-than options do the same for a given time,
+// either a duration before now like 30d or 12h, or an RFC3339 timestamp
+// like 2016-05-01T00:00:00Z. Combined with the -file options, the
+// stricter bound wins.
+//
+// The -content-match option only considers files whose contents match
+// the given regular expression; only the first -content-limit bytes of
+// each file are searched. This reads every file, so it's slow.
+//
+// The -skip-special-fs option skips directories on pseudo-filesystems
+// such as procfs or sysfs; this only works on Linux, macOS, FreeBSD, and
+// DragonFly.
+//
+// The -warn-special option prints a warning for each file that's neither
+// regular nor a directory: symlinks, named pipes, sockets, and devices.
+//
+// The -jobs-file option reads independent jobs from the given file, one
+// per line. Each line lists options and paths just like the command line
+// does. Jobs run one after another, each starting from a clean slate and
+// the options given on the command line. Empty lines and lines starting
+// with # are ignored.
+//
+// The -from-stdin option also examines the files listed on stdin, one per
+// line or NUL-terminated with -0, as if each was given as a path.
+//
+// The -hashes-stdin option reads "hash size path" lines from stdin, as
+// computed elsewhere, and finds duplicates among those without touching
+// any files.
+//
+// The -revalidate option checks that all files still exist before they
+// are reported; vanished duplicates are dropped, and if an original has
+// vanished, one of its duplicates takes its place.
+//
+// The -relative option prints the paths in clusters relative to the
+// path given that they were found under; with several paths, each is
+// prefixed by that path in brackets.
+//
+// The -abs option makes the paths given absolute before anything else
+// happens, so all paths printed are absolute, in every output format.
+//
+// The -show-common-ancestor option prints the longest directory path all
+// files in a cluster share before the cluster itself.
+//
+// The -show-hash option prints the first few hex digits of the digest
+// of a cluster, followed by a colon, before its paths.
+//
+// The -redundant-dirs option also reports directories all of whose files
+// have copies elsewhere, so the whole directory could go. Files that
+// other options filter out, as well as directories we skip, have no copy
+// as far as we know, so their directories are never redundant.
+//
+// The -ext-summary option also reports how many duplicates there are for
+// each file extension and how much space they waste, most space first.
+//
+// The -rsync-excludes option writes an rsync exclude rule for each
+// duplicate (but not the originals) to the given file; rules are
+// anchored at the path the duplicate was found under.
+//
+// The -table-stats option prints the statistics as a table with one
+// line for each number instead of as a single sentence.
+//
+// The -json option prints the clusters and statistics as a single JSON
+// document instead.
+//
+// The -jsonl option prints one JSON object per line instead, first one
+// for each cluster and then one for the statistics; a "type" field tells
+// them apart.
+//
+// The -csv option prints one CSV row per file in a cluster instead, with
+// its digest, size, cluster number, role, and path.
+//
+// The -stats-json option prints just the statistics as a JSON object,
+// without any clusters.
+//
+// The -tree-hash option doesn't look for duplicates at all; instead it
+// prints one digest for each path, combining the relative paths and the
+// contents of all regular files below it. Two trees with the same digest
+// have the same structure and the same contents.
+//
+// The -git option doesn't look for duplicates among the given paths
+// either; instead it prints the files whose contents are a blob in the
+// history of the given git repository. It selects files like the usual
+// walk does. It's experimental.
+//
+// The -skip-linked option skips files that are hard links to a file we
+// have already seen; they don't waste any space after all. This only
+// works on Unix.
+//
+// The -link-preflight option checks, for each cluster, whether all its
+// files are on the same filesystem so they could be hard linked; this
+// also only works on Unix.
+//
+// The -auto-safe-under option splits the clusters into two sections: a
+// cluster is "auto-safe" if all its duplicates are below the given
+// directory but its original is not; every other cluster "needs review".
+//
+// The -chunk option additionally splits each file into content-defined
+// chunks and reports how much space duplicate chunks waste; this finds
+// partial duplicates like similar disk images. It's experimental.
+//
+// The -phash option additionally looks for images that are almost the
+// same, say resized or re-encoded copies, by comparing perceptual hashes;
+// -threshold sets how many of their 64 bits may differ. Near-duplicates
+// are reported separately. It's experimental.
+//
+// The -cache option remembers the digest of each file, along with its
+// size and modification time, in the given file; the next run with the
+// same file only hashes files that changed.
+//
+// The -spill option keeps the size and path of each file in temporary
+// files instead of memory and collates them after the walk, one partition
+// at a time; this is slower but saves the memory for the files that turn
+// out to have no duplicates. The duplicates found are still kept in
+// memory.
+//
+// The -stream option prints each duplicate as soon as it's found, as its
+// original and its path separated by a tab, instead of clusters at the
+// end. Files are hashed one at a time during the walk, so the output
+// comes early but isn't sorted or grouped by cluster: lines come in the
+// order the walk finds the duplicates, and an original that already had
+// a line can get more later on. The statistics still come at the end.
+// Clusters aren't kept in memory, so the options that need them can't be
+// used with -stream.
+//
+// The -list-dupes option prints only the duplicates in all clusters, one
+// per line, and nothing else; the -list-originals option prints only the
+// originals instead.
+//
+// The -0 option prints only the paths in all clusters, each terminated
+// by a NUL byte, for use with xargs -0; nothing else goes to stdout.
+// With -list-dupes or -list-originals, those paths are terminated by a
+// NUL byte instead.
+//
+// The -no-summary-on-empty option makes dupes print nothing at all, not
+// even the statistics, if it doesn't find any duplicates. Together with
+// -q, which prints only the statistics, dupes prints those if it finds
+// duplicates and nothing otherwise. With -stats-json, the statistics are
+// always printed.
+//
+// The -fold-case option considers text files that only differ in the case
+// of ASCII letters duplicates; binary files are compared as usual.
+//
+// The -ignore-metadata option compares only the image or audio data of
+// JPEG, PNG, and MP3 files, ignoring metadata like EXIF or ID3 tags. It's
+// experimental.
+//
+// The -with-xattr option considers two files duplicates only if their
+// extended attributes match as well; this only works on Linux.
+//
+// The -same-name option considers two files duplicates only if their
+// names (without the directories) match as well.
+//
+// The -same-dir option considers two files duplicates only if they are
+// in the same directory as well.
+//
+// The -precount option first walks all paths just to count the files
+// (without reading them) so it can show the percentage done on stderr
+// while it scans.
+//
+// The -L option follows symbolic links to files and directories instead
+// of ignoring them; dupes keeps track of the directories it has been to
+// so links can't make it walk in circles.
+//
+// The -progress option shows the number of files examined, the bytes
+// hashed so far, and the current path on stderr while dupes scans.
+//
+// The -delete option asks, for each duplicate, whether to remove it;
+// originals are never removed.
+//
+// The -hardlink option replaces each duplicate with a hard link to its
+// original; duplicates on another filesystem are skipped.
+//
+// The -against option walks the given reference directory first and then
+// only reports files in the paths given that already exist in it, each in
+// a cluster with its match in the reference directory as the original.
+// Duplicates within the reference directory, or only among the new files,
+// aren't reported.
+//
+// The -dry-run option makes -delete and -hardlink only print what they
+// would do, without asking and without touching any files.
+//
+// With -json or -jsonl, -delete and -hardlink act before the results are
+// printed, and the results say what happened to each duplicate.
+//
+// The -action-manifest option writes a CSV row for every duplicate that
+// -delete removed or -hardlink replaced, with the digest and size from
+// the scan, its path, and its original; with -dry-run the rows say what
+// would happen instead.
+//
+// Before -delete or -hardlink touch any files, dupes says how many files
+// and how much space they affect and asks whether to go ahead; the -yes
+// option skips that question.
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/sha1"
+	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"runtime/pprof"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
 	globDefault = "*"
 )
 
+// exit statuses
+const (
+	exitClean = 0 // no duplicates found
+	exitDupes = 1 // duplicates found
+	exitError = 2 // something went wrong
+)
+
 var (
-	paranoid    = flag.Bool("p", false, "paranoid byte-by-byte file comparison")
+	paranoid    = flag.Bool("p", false, "paranoid byte-by-byte file comparison (on by default with -hash crc32 or xxhash, use -p=false to turn it off)")
 	minimumSize = flag.Int64("s", 1, "minimum size (in bytes) of files to consider")
-	globbing    = flag.String("g", globDefault, "glob expression for files to consider")
+	includeZero = flag.Bool("include-empty", false, "also consider empty files, which are all duplicates of each other")
+	ignoreFrom  = flag.String("ignore-from", "", "read patterns for files and directories to skip from file (.gitignore-style)")
+	noHidden    = flag.Bool("no-hidden", false, "skip files and directories whose names start with a dot")
+	maximumSize = flag.Int64("S", 0, "maximum size (in bytes) of files to consider (0 for no maximum)")
+	globbing    = flag.String("g", globDefault, "glob expressions for files to consider, separated by commas")
+	globFold    = flag.Bool("i", false, "ignore case when matching file names against -g")
+	fileTypes   = flag.String("types", "", "comma-separated categories of files to consider: "+categoryNames())
+	nameRegex   = flag.String("regex", "", "regular expression for files to consider (instead of -g)")
+	regexPath   = flag.Bool("regexpath", false, "match -regex against paths relative to the root instead of file names")
+	useMmap     = flag.Bool("mmap", false, "compare files for -p by mapping them into memory instead of reading them (Unix only)")
+	bufferSize  = flag.Int("bufsize", 1<<20, "buffer size (in bytes) for reading files")
+	prefixSize  = flag.Int64("prefix", 64*1024, "bytes to hash first to rule out large files quickly (0 hashes whole files right away)")
+	workers     = flag.Int("j", runtime.NumCPU(), "number of files to hash concurrently")
+	hashName    = flag.String("hash", hashDefault, "hash algorithm to use: "+hashNames())
+	newerFile   = flag.String("newer-than-file", "", "only consider files modified after the given file")
+	olderFile   = flag.String("older-than-file", "", "only consider files modified before the given file")
+	newerTime   = flag.String("newer-than", "", "only consider files modified after the given time (duration like 30d or 12h ago, or RFC3339)")
+	olderTime   = flag.String("older-than", "", "only consider files modified before the given time (duration like 30d or 12h ago, or RFC3339)")
+	grepContent = flag.String("content-match", "", "only consider files whose contents match the given regular expression")
+	grepLimit   = flag.Int64("content-limit", 16*1024*1024, "how many bytes (at most) of each file -content-match searches")
+	caseFold    = flag.Bool("fs-case-insensitive", false, "treat paths as case-insensitive when checking whether roots overlap")
+	skipSpecial = flag.Bool("skip-special-fs", false, "skip pseudo-filesystems like /proc, /sys, and /dev (Linux, macOS, FreeBSD)")
+	warnSpecial = flag.Bool("warn-special", false, "warn about symlinks, named pipes, sockets, and devices")
+	preflight   = flag.Bool("link-preflight", false, "check which clusters could be replaced by hard links (Unix only)")
+	skipLinked  = flag.Bool("skip-linked", false, "skip files that are hard links to files already seen (Unix only)")
+	autoSafeDir = flag.String("auto-safe-under", "", "label clusters whose duplicates are all under the given directory as auto-safe")
+	chunking    = flag.Bool("chunk", false, "also look for duplicate chunks within and across files (experimental)")
+	phash       = flag.Bool("phash", false, "also look for near-duplicate JPEG, PNG, and GIF images by perceptual hash (experimental)")
+	threshold   = flag.Int("threshold", 5, "how many bits perceptual hashes may differ in for -phash")
+	quietEmpty  = flag.Bool("no-summary-on-empty", false, "print nothing at all, not even the statistics from -q, if no duplicates are found")
+	foldCase    = flag.Bool("fold-case", false, "ignore differences in ASCII letter case within text files")
+	ignoreMeta  = flag.Bool("ignore-metadata", false, "ignore metadata like EXIF and ID3 tags in JPEG, PNG, and MP3 files (experimental)")
+	withXattr   = flag.Bool("with-xattr", false, "files must also have the same extended attributes to be duplicates (Linux only)")
+	sameName    = flag.Bool("same-name", false, "files must also have the same name to be duplicates")
+	sameDir     = flag.Bool("same-dir", false, "files must also be in the same directory to be duplicates")
+	progress    = flag.Bool("progress", false, "show files examined, bytes hashed, and the current path on stderr")
+	follow      = flag.Bool("L", false, "follow symbolic links to files and directories")
+	precount    = flag.Bool("precount", false, "count files first to show progress percentage on stderr")
+	relative    = flag.Bool("relative", false, "print paths in clusters relative to the path they were found under")
+	absPaths    = flag.Bool("abs", false, "print absolute paths even for relative paths given")
+	showCommon  = flag.Bool("show-common-ancestor", false, "print the common ancestor directory before each cluster")
+	showHash    = flag.Bool("show-hash", false, "print the first 8 hex digits of the digest before each cluster")
+	redundant   = flag.Bool("redundant-dirs", false, "report directories all of whose files have copies elsewhere")
+	siSizes     = flag.Bool("si", false, "print sizes in powers of 1000 (kB, MB, GB) instead of 1024")
+	extReport   = flag.Bool("ext-summary", false, "report duplicates and wasted space by file extension")
+	rsyncFile   = flag.String("rsync-excludes", "", "write rsync exclude rules for all duplicates to file")
+	statsJSON   = flag.Bool("stats-json", false, "print only the statistics, as JSON")
+	recheck     = flag.Bool("revalidate", false, "make sure all files still exist before reporting them")
+	jsonOut     = flag.Bool("json", false, "print clusters and statistics as a JSON document")
+	jsonLines   = flag.Bool("jsonl", false, "print clusters and statistics as JSON objects, one per line")
+	sortBy      = flag.String("sort", "path", "order clusters by path, size (space wasted), or count (duplicates)")
+	keepRule    = flag.String("keep", "first", "which file of a cluster is the original: first (found), oldest, newest, or shortest-path")
+	minCluster  = flag.Int("min-cluster", 2, "only report clusters with at least this many files")
+	minCount    = flag.Int("min-count", 0, "also report the space wasted in clusters with at least this many files")
+	listDupes   = flag.Bool("list-dupes", false, "print only the duplicates, one per line, not the originals")
+	listOrigs   = flag.Bool("list-originals", false, "print only the originals of all clusters, one per line")
+	nulOut      = flag.Bool("0", false, "print only paths, each terminated by a NUL byte (for xargs -0); read -from-stdin that way too")
+	verbose     = flag.Bool("v", false, "log each file hashed or skipped on stderr")
+	quiet       = flag.Bool("q", false, "print only the statistics, not the clusters")
+	countOnly   = flag.Bool("count-only", false, "print only the statistics and don't keep clusters in memory")
+	noStats     = flag.Bool("no-stats", false, "print only the clusters, without sizes or statistics")
+	csvOut      = flag.Bool("csv", false, "print one CSV row per file in a cluster")
+	tableStats  = flag.Bool("table-stats", false, "print statistics as a table")
+	cacheFile   = flag.String("cache", "", "remember digests in file to skip unchanged files next time")
+	spilling    = flag.Bool("spill", false, "keep file sizes and paths in temporary files instead of memory (slower, duplicates are still kept in memory)")
+	streaming   = flag.Bool("stream", false, "print each duplicate as soon as it's found, in the order the walk finds them (an original can come up again later)")
+	treeHashes  = flag.Bool("tree-hash", false, "print one digest over paths and contents for each root")
+	gitDir      = flag.String("git", "", "find files whose contents are in the history of the given git repository (experimental)")
+	deleting    = flag.Bool("delete", false, "interactively remove duplicates, keeping originals")
+	hardlink    = flag.Bool("hardlink", false, "replace duplicates with hard links to their originals")
+	dryRun      = flag.Bool("dry-run", false, "only say what -delete or -hardlink would do")
+	manifest    = flag.String("action-manifest", "", "write what -delete or -hardlink did to each duplicate to file, as CSV")
+	yes         = flag.Bool("yes", false, "don't ask for confirmation before -delete or -hardlink start")
+	fromStdin   = flag.Bool("from-stdin", false, "also examine the files listed on stdin, one per line (or NUL-terminated with -0)")
+	againstDir  = flag.String("against", "", "only report files that already exist in the given directory, not duplicates among them")
+	fromHashes  = flag.Bool("hashes-stdin", false, "find duplicates among \"hash size path\" lines read from stdin")
+	outFile     = flag.String("o", "", "write results to file instead of stdout")
+	logFile     = flag.String("log", "", "append warnings and errors to file, with timestamps, instead of writing them to stderr")
+	collisions  = flag.String("collisions", "", "report hash collisions found by -p to file instead of stderr")
+	jobsFile    = flag.String("jobs-file", "", "run the jobs listed in the given file, one per line")
+	stateFile   = flag.String("dump-state", "", "write internal maps to file as JSON (development only)")
 	cpuprofile  = flag.String("cpuprofile", "", "write cpu profile to file (development only)")
+	memprofile  = flag.String("memprofile", "", "write heap profile to file after the walk (development only)")
 )
 
 var (
-	hashes = make(map[string]string)   // maps from digests to paths
-	sizes  = make(map[int64]string)    // maps from sizes to paths
-	final  = make(map[string][]string) // maps from paths to duplicate paths (collates all dupes)
+	hashes  = make(map[string]string)   // maps from digests to originals (only digests with duplicates)
+	sizes   = make(map[int64]string)    // maps from sizes to paths
+	final   = make(map[string]*cluster) // maps from originals to their clusters (collates all dupes)
+	counted = make(map[string]bool)     // originals with duplicates (only for -count-only and -stream)
+
+	inodes = make(map[fileKey]string) // maps from inodes to paths (only for -skip-linked)
+
+	given map[string]bool // names of the options given explicitly
+
+	byteCompare bool // compare files byte-by-byte, with -p or because of -hash crc32 or xxhash
+
+	globs []string // patterns from -g, file names must match one of them
+
+	nameRegexp *regexp.Regexp // what file names (or paths) must match (nil means anything)
+
+	contentRegexp *regexp.Regexp // what file contents must match (nil means anything)
+
+	newerThan time.Time // modification time files must be after (zero means any)
+	olderThan time.Time // modification time files must be before (zero means any)
 
 	files  counter  // number of files examined
 	dupes  counter  // number of duplicate files
 	wasted bytesize // space (in bytes) occupied by duplicates
+	linked counter  // number of hard links skipped (or not counted as duplicates)
+
+	denied counter // number of files and directories we weren't allowed to read
+
+	failed bool // couldn't walk a root or otherwise went wrong badly
+
+	warnedSpecial bool // said that -skip-special-fs does nothing here (once for all jobs)
+
+	scanned bytesize // space (in bytes) occupied by files examined
 )
 
+// fileKey identifies a file independent of the path(s) used to reach it.
+type fileKey struct {
+	dev, ino uint64
+}
+
 // fileContentsMatch does a byte-by-byte comparison of the files with the
-// given paths
-func fileContentsMatch(pa, pb string) (bool, error) {
-	a, err := os.Open(pa)
+// given paths; if they differ, it also returns the offset of the first
+// byte that does.
+func fileContentsMatch(pa, pb string) (bool, int64, error) {
+	// mapped files are compared as they are on disk, without the
+	// transformations openContent may apply
+	if *useMmap && !*foldCase && !*ignoreMeta {
+		if same, offset, ok := mmapMatch(pa, pb); ok {
+			return same, offset, nil
+		}
+	}
+
+	a, err := openContent(pa)
 	if err != nil {
-		return false, err
+		return false, 0, err
 	}
 	defer a.Close()
-	b, err := os.Open(pb)
+	b, err := openContent(pb)
 	if err != nil {
-		return false, err
+		return false, 0, err
 	}
 	defer b.Close()
 
 	return fileContentsHelper(a, b)
 }
 
-func fileContentsHelper(a, b io.Reader) (bool, error) {
-	bufferSize := os.Getpagesize()
-
-	ba := make([]byte, bufferSize)
-	bb := make([]byte, bufferSize)
+// fileContentsHelper compares the given readers byte-by-byte; see
+// fileContentsMatch.
+func fileContentsHelper(a, b io.Reader) (bool, int64, error) {
+	ba, bb := getBuffer(), getBuffer()
+	defer buffers.Put(ba)
+	defer buffers.Put(bb)
 
+	var offset int64 // where the current stretch starts
 	for {
-		la, erra := a.Read(ba)
-		lb, errb := b.Read(bb)
-
-		// specification of Read() says to check returned size
-		// before considering errors; who are we to disagree?
-		if la > 0 || lb > 0 {
-			// it's okay to use Equal here because whatever may
-			// be left behind in the buffers after a short read
-			// had to be Equal in the prior iteration; note that
-			// we don't have to check la == lb either because if
-			// they were not, Equal should fail
-			if !bytes.Equal(ba, bb) {
-				return false, nil
-			}
+		// ReadFull keeps going until the buffer is full, so both
+		// sides always look at the same stretch of their file even
+		// if the underlying Read() returns short; only at the end
+		// of a file do we get less than a full buffer
+		la, erra := io.ReadFull(a, ba)
+		lb, errb := io.ReadFull(b, bb)
+
+		// only compare what was actually read this time around;
+		// whatever is left behind in the buffers is stale
+		if la != lb || !bytes.Equal(ba[:la], bb[:lb]) {
+			return false, offset + firstDifference(ba[:la], bb[:lb]), nil
 		}
+		offset += int64(la)
 
-		// specification of Read() says that sooner or later we'll
-		// see io.EOF regardless of returned size; only if both
-		// files end in the same iteration (and made it past Equal
-		// above) do we have a duplicate
+		enda := erra == io.EOF || erra == io.ErrUnexpectedEOF
+		endb := errb == io.EOF || errb == io.ErrUnexpectedEOF
+
+		// only if both files end in the same iteration (and made it
+		// past Equal above) do we have a duplicate
 		switch {
-		case erra == io.EOF && errb == io.EOF:
-			return true, nil
-		case erra != nil:
-			return false, erra
-		case errb != nil:
-			return false, errb
+		case enda && endb:
+			return true, 0, nil
+		case erra != nil && !enda:
+			return false, 0, erra
+		case errb != nil && !endb:
+			return false, 0, errb
+		case enda || endb:
+			return false, offset, nil
 		}
 	}
 }
 
+// buffers recycles read buffers of -bufsize bytes; most files are small,
+// so allocating a fresh buffer for each would keep the GC busy.
+var buffers sync.Pool
+
+// getBuffer returns a buffer of -bufsize bytes, put it back with
+// buffers.Put when done.
+func getBuffer() []byte {
+	if b, ok := buffers.Get().([]byte); ok && len(b) == *bufferSize {
+		return b
+	}
+	return make([]byte, *bufferSize)
+}
+
+// copyBuffered is io.Copy with a buffer of -bufsize bytes; io.Copy would
+// pick its own small buffer, or none at all if src has a WriteTo method,
+// so we hide that.
+func copyBuffered(dst io.Writer, src io.Reader) (int64, error) {
+	buf := getBuffer()
+	defer buffers.Put(buf)
+	return io.CopyBuffer(dst, struct{ io.Reader }{src}, buf)
+}
+
+// firstDifference returns the index of the first byte that differs in
+// the given slices; if one is a prefix of the other, that's the length
+// of the shorter one.
+func firstDifference(a, b []byte) int64 {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return int64(i)
+}
+
 // checksum calculates a hash digest for the file with the given path
-func checksum(path string) (string, error) {
-	file, err := os.Open(path)
+// using a hasher from the given constructor
+func checksum(path string, newHash func() hash.Hash) (string, error) {
+	file, err := openContent(path)
 	if err != nil {
 		return "", err
 	}
 	defer file.Close()
 
-	hasher := sha1.New()
-	_, err = io.Copy(hasher, file)
+	hasher := newHash()
+	n, err := copyBuffered(hasher, file)
+	hashed(n)
+	verbosef("hashed %s (%d bytes)", path, n)
 	sum := fmt.Sprintf("%x", hasher.Sum(nil))
 
 	return sum, err
 }
 
-// check is called for each path we walk. It only examines regular, non-empty
-// files. It first rules out duplicates by file size; for files that remain
-// it calculates a checksum; if it has seen the same checksum before, it
-// signals a duplicate; otherwise it remembers the checksum and the path of
-// the original file before moving on; in paranoid mode it follows up with a
-// byte-by-byte file comparison.
-func check(path string, info os.FileInfo, err error) error {
+// prefixChecksum is like checksum but only looks at the first n bytes of
+// the file with the given path.
+func prefixChecksum(path string, n int64) (string, error) {
+	file, err := openContent(path)
 	if err != nil {
-		return err
+		return "", err
 	}
+	defer file.Close()
 
-	size := info.Size()
+	hasher := newHash()
+	m, err := copyBuffered(hasher, io.LimitReader(file, n))
+	hashed(m)
+	verbosef("hashed prefix of %s (%d bytes)", path, m)
+	sum := fmt.Sprintf("%x", hasher.Sum(nil))
+
+	return sum, err
+}
+
+// digest calculates the key we use to collate the file with the given
+// path; usually that's just its checksum, but with -with-xattr we also
+// throw in a hash of its extended attributes. With -cache we only hash
+// files that changed since the last run; changing extended attributes
+// doesn't change the modification time, so we never cache those.
+func digest(path string) (string, error) {
+	var sum string
+	var err error
+	if cache != nil {
+		sum, err = cache.cachedDigest(path, calculateDigest)
+	} else {
+		sum, err = calculateDigest(path)
+	}
+	if err != nil {
+		return sum, err
+	}
+	return addXattr(path, sum)
+}
+
+// calculateDigest is digest without the cache and extended attributes.
+func calculateDigest(path string) (string, error) {
+	return checksum(path, newHash)
+}
+
+// addXattr adds a hash of the extended attributes of the file with the
+// given path to the given digest of its contents, with -with-xattr.
+func addXattr(path, sum string) (string, error) {
+	if !*withXattr {
+		return sum, nil
+	}
+	xsum, err := xattrHash(path)
+	if err != nil {
+		return "", err
+	}
+	return sum + "+" + xsum, nil
+}
+
+// collateKey is the key we collate the file with the given path and
+// digest under; with -same-name files with different names never meet,
+// with -same-dir files in different directories don't.
+func collateKey(path, sum string) string {
+	key := sum
+	if *sameName {
+		key += "/" + filepath.Base(path)
+	}
+	if *sameDir {
+		key += "/" + filepath.Dir(path)
+	}
+	return key
+}
+
+// fileType describes the type of a file that is neither regular nor
+// a directory.
+func fileType(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeSymlink != 0:
+		return "symbolic link"
+	case mode&os.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeCharDevice != 0:
+		return "character device"
+	case mode&os.ModeDevice != 0:
+		return "block device"
+	default:
+		return "irregular file"
+	}
+}
+
+// globMatch checks whether the given file name matches any of the -g
+// patterns; checkOptions made sure they are all valid.
+//
+// With -i both patterns and names are lowercased first. That's simple
+// lowercasing, not Unicode case folding: it works for "*.JPG" and
+// "*.jpg" but not for letters that fold to something else entirely, so
+// "STRASSE" won't match "straße". It's also not quite what case
+// insensitive filesystems do, but close enough for picking files.
+func globMatch(name string) bool {
+	if *globFold {
+		name = strings.ToLower(name)
+	}
+	for _, pattern := range globs {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// regexMatch checks whether the file with the given path and name matches
+// -regex. With -regexpath we match the path relative to the root we're
+// walking, with slashes as separators on every platform.
+func regexMatch(path, name string) bool {
+	if !*regexPath {
+		return nameRegexp.MatchString(name)
+	}
+	if rel, err := filepath.Rel(walkRoot, path); err == nil {
+		path = rel
+	}
+	return nameRegexp.MatchString(filepath.ToSlash(path))
+}
 
-	if !info.Mode().IsRegular() || size < *minimumSize {
+// candidate checks whether the file with the given path and info is one
+// we care about: a regular file of the right size and with the right name.
+// If it's not, it also says why.
+func candidate(path string, info os.FileInfo) (bool, string) {
+	switch {
+	case !info.Mode().IsRegular():
+		return false, "not a regular file"
+	case info.Size() < *minimumSize && !(*includeZero && info.Size() == 0):
+		return false, "smaller than -s"
+	case *maximumSize > 0 && info.Size() > *maximumSize:
+		return false, "larger than -S"
+	case *globbing != globDefault && !globMatch(info.Name()):
+		return false, "doesn't match -g"
+	case nameRegexp != nil && !regexMatch(path, info.Name()):
+		return false, "doesn't match -regex"
+	case typeExts != nil && !typeMatch(info.Name()):
+		return false, "not in -types"
+	case !newerThan.IsZero() && !info.ModTime().After(newerThan):
+		return false, "not modified after " + newerThan.Format(time.RFC3339)
+	case !olderThan.IsZero() && !info.ModTime().Before(olderThan):
+		return false, "not modified before " + olderThan.Format(time.RFC3339)
+	}
+	return true, ""
+}
+
+// unreadable deals with an error walking the given path: if we just
+// weren't allowed to read it, or it stopped being a regular file, we say
+// so and go on with the rest of the walk; anything else ends the walk.
+func unreadable(path string, err error) error {
+	if errors.Is(err, errNotRegular) {
+		fmt.Fprintf(errOut, "warning: skipping %s (%v)\n", path, err)
 		return nil
 	}
+	if !errors.Is(err, fs.ErrPermission) {
+		return err
+	}
+	fmt.Fprintf(errOut, "warning: skipping %s (%v)\n", path, err)
+	denied++
+	return nil
+}
+
+// filter decides whether a walk with the given walk function, called for
+// the given path, should examine it: it applies all the options that
+// select files, and deals with errors, symlinks, and directories. If the
+// path isn't a file to examine, the walk function should return the error
+// (if any) right away.
+func filter(path string, info os.FileInfo, err error, walk filepath.WalkFunc) (bool, error) {
+	if interrupted() {
+		return false, errInterrupted
+	}
+
+	if err != nil {
+		countSkipped(path)
+		return false, unreadable(path, err)
+	}
+
+	if path != walkRoot && excluded(path, info) {
+		countSkipped(path)
+		if info.IsDir() {
+			return false, filepath.SkipDir
+		}
+		return false, nil
+	}
+
+	if *follow && info.Mode()&os.ModeSymlink != 0 {
+		return false, followLink(path, walk)
+	}
+
+	if seenBefore(path) {
+		if info.IsDir() {
+			return false, filepath.SkipDir
+		}
+		return false, nil
+	}
+
+	if info.IsDir() && tooDeep(path) {
+		countSkipped(path)
+		return false, filepath.SkipDir
+	}
+
+	if info.IsDir() && *follow && !firstVisit(path, info) {
+		return false, filepath.SkipDir
+	}
 
-	if *globbing != globDefault {
-		matched, err := filepath.Match(*globbing, info.Name())
+	if info.IsDir() && *skipSpecial {
+		if fs, ok := specialFilesystem(path); ok {
+			fmt.Fprintf(errOut, "warning: skipping %s (special filesystem %s)\n", path, fs)
+			countSkipped(path)
+			return false, filepath.SkipDir
+		}
+	}
+
+	if *warnSpecial && !info.IsDir() && !info.Mode().IsRegular() {
+		fmt.Fprintf(errOut, "warning: skipping %s (%s)\n", path, fileType(info.Mode()))
+	}
+
+	// every file counts for -redundant-dirs, even if the options below
+	// filter it out: it has no copy we know of, so its directory isn't
+	// redundant
+	if *redundant && !info.IsDir() {
+		countDir(path, info.Size())
+	}
+
+	if ok, why := candidate(path, info); !ok {
+		if !info.IsDir() {
+			verbosef("skipping %s (%s)", path, why)
+		}
+		return false, nil
+	}
+
+	if contentRegexp != nil {
+		matched, err := contentMatches(path)
 		if err != nil {
-			return err
+			return false, unreadable(path, err)
 		}
 		if !matched {
-			return nil
+			return false, nil
 		}
 	}
 
+	return true, nil
+}
+
+// check is called for each path we walk. It only examines regular, non-empty
+// files. It first rules out duplicates by file size; files that remain are
+// handed to the hash pool which calculates their checksums concurrently;
+// once the walk is done, the pool collates the checksums (see collate).
+func check(path string, info os.FileInfo, err error) error {
+	if ok, err := filter(path, info, err, check); !ok {
+		return err
+	}
+
+	size := info.Size()
+
 	files++
+	scanned += bytesize(size)
+
+	if cache != nil {
+		cache.saw(path)
+	}
+
+	if *precount || *progress {
+		showProgress(path, bytesize(size))
+	}
+
+	if *chunking {
+		if err := chunkFile(path); err != nil {
+			return err
+		}
+	}
+
+	if *phash && phashImage(info.Name()) {
+		phashPaths = append(phashPaths, path)
+	}
+
+	if *skipLinked {
+		if id, ok := fileID(info); ok {
+			if _, ok := inodes[id]; ok {
+				linked++
+				return nil
+			}
+			inodes[id] = path
+		}
+	}
+
+	if size == 0 {
+		return recordEmpty(path)
+	}
+
+	if *ignoreMeta {
+		media, err := isMedia(path)
+		if err != nil {
+			return unreadable(path, err)
+		}
+		if media {
+			return checkMedia(path, size)
+		}
+	}
+
+	if spill != nil {
+		return spill.add(size, path)
+	}
 
-	var dupe string
-	var ok bool
-	if dupe, ok = sizes[size]; !ok {
+	first, ok := sizes[size]
+	if !ok {
 		sizes[size] = path
 		return nil
 	}
 
-	// backpatch new file into hashes
-	sum, err := checksum(dupe)
-	if err != nil {
-		return err
+	if *streaming {
+		streamDupe(path, size, first)
+		return nil
 	}
-	hashes[sum] = dupe
 
-	sum, err = checksum(path)
-	if err != nil {
-		return err
+	// hand files to the hash pool as soon as their size collides; the
+	// first file of each size is hashed only once, when the first
+	// collision happens
+	if len(buckets[size]) == 0 {
+		e := &fileEntry{path: first, size: size}
+		buckets[size] = []*fileEntry{e}
+		pool.submit(e)
 	}
+	e := &fileEntry{path: path, size: size}
+	buckets[size] = append(buckets[size], e)
+	pool.submit(e)
+
+	return nil
+}
+
+// cluster is an original and all its duplicates; they have the same
+// size and digest.
+type cluster struct {
+	original   string
+	duplicates []string
+	size       int64
+	sum        string
+}
+
+// members returns all files in the cluster, original first.
+func (c *cluster) members() []string {
+	return append([]string{c.original}, c.duplicates...)
+}
 
-	if dupe, ok = hashes[sum]; !ok {
-		hashes[sum] = path
+// waste returns the space wasted by the duplicates in the cluster.
+func (c *cluster) waste() bytesize {
+	return bytesize(c.size) * bytesize(len(c.duplicates))
+}
+
+// recordDupe records the file with the given path, digest, and size as a
+// duplicate of the given original; in paranoid mode it first makes sure
+// with a byte-by-byte file comparison.
+func recordDupe(path, dupe, sum string, size int64) error {
+	// with -hashes-stdin the paths may not even mean the same thing here
+	if !*fromHashes && alreadyLinked(path, dupe) {
+		linked++
 		return nil
 	}
 
-	if *paranoid {
-		same, err := fileContentsMatch(path, dupe)
+	// empty files are all the same, there's nothing to compare
+	if byteCompare && size > 0 {
+		same, offset, err := fileContentsMatch(path, dupe)
 		if err != nil {
 			return err
 		}
 		if !same {
-			fmt.Printf("cool: %s sha1-collides with %s!\n", path, dupe)
+			fmt.Fprintf(collisionOut, "collision: %s and %s share %s digest %s but differ at byte %d\n", path, dupe, *hashName, sum, offset)
 			return nil
 		}
 	}
@@ -195,21 +988,699 @@ func check(path string, info os.FileInfo, err error) error {
 	dupes++
 	wasted += bytesize(size)
 
-	final[dupe] = append(final[dupe], path)
+	if *countOnly || *streaming {
+		counted[dupe] = true
+		return nil
+	}
+
+	c, ok := final[dupe]
+	if !ok {
+		c = &cluster{original: dupe, size: size, sum: sum}
+		final[dupe] = c
+	}
+	c.duplicates = append(c.duplicates, path)
 
 	return nil
 }
 
+// dropSmallClusters forgets all clusters with fewer than the given number
+// of files, original included, as if we had never found them.
+func dropSmallClusters(min int) {
+	for k, c := range final {
+		if len(c.duplicates)+1 >= min {
+			continue
+		}
+		dupes -= counter(len(c.duplicates))
+		wasted -= c.waste()
+		delete(final, k)
+	}
+}
+
+// originals returns the originals of all clusters in no particular
+// order; unlike sortedDupes it leaves the duplicates in the order we found
+// them, and it's safe to change final while going through them.
+func originals() []string {
+	ks := make([]string, 0, len(final))
+	for k := range final {
+		ks = append(ks, k)
+	}
+	return ks
+}
+
+// sortedDupes returns the originals of all clusters in the order -sort
+// asks for: by path, by space wasted, or by number of duplicates, the
+// latter two biggest first and by path for ties. It also sorts the
+// duplicates of each cluster so that output over the same tree is always
+// the same, no matter in what order the files were found.
 func sortedDupes() []string {
+
 	var sk []string
-	for k := range final {
+	for k, c := range final {
 		sk = append(sk, k)
+		sort.Strings(c.duplicates)
 	}
 	sort.Strings(sk)
+
+	switch *sortBy {
+	case "size":
+		sort.SliceStable(sk, func(i, j int) bool {
+			return final[sk[i]].waste() > final[sk[j]].waste()
+		})
+	case "count":
+		sort.SliceStable(sk, func(i, j int) bool {
+			return len(final[sk[i]].duplicates) > len(final[sk[j]].duplicates)
+		})
+	}
 	return sk
 }
 
+// printClusters prints the clusters with the given originals, each
+// followed by an empty line. With -relative, paths are printed relative
+// to the given roots.
+func printClusters(originals, roots []string) {
+	for _, k := range originals {
+		vs := final[k].duplicates
+		if !*noStats {
+			fmt.Fprintf(out, "# %v copies, %v wasted\n", counter(len(vs)+1), final[k].waste())
+		}
+		if metaIgnored[k] {
+			fmt.Fprintln(out, "# metadata ignored")
+		}
+		if *showCommon {
+			fmt.Fprintf(out, "# common ancestor: %s\n", commonAncestor(append([]string{k}, vs...)))
+		}
+		if *showHash {
+			fmt.Fprintf(out, "%s:\n", shortHash(final[k].sum))
+		}
+		fmt.Fprintln(out, display(k, roots))
+		for _, v := range vs {
+			fmt.Fprintln(out, display(v, roots))
+		}
+		fmt.Fprintln(out)
+	}
+}
+
+// shortHash returns the first 8 hex digits of the given digest, which is
+// plenty to tell clusters apart at a glance.
+func shortHash(sum string) string {
+	if len(sum) > 8 {
+		return sum[:8]
+	}
+	return sum
+}
+
+// printPaths prints the paths in the clusters of the given originals,
+// each followed by the given terminator, and nothing else; originals and
+// duplicates say which of them to print.
+func printPaths(sorted []string, originals, duplicates bool, end string) {
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	for _, k := range sorted {
+		if originals {
+			fmt.Fprintf(w, "%s%s", k, end)
+		}
+		if duplicates {
+			for _, v := range final[k].duplicates {
+				fmt.Fprintf(w, "%s%s", v, end)
+			}
+		}
+	}
+}
+
+// reset forgets everything we learned during a previous scan.
+func reset() {
+	hashes = make(map[string]string)
+	sizes = make(map[int64]string)
+	buckets = make(map[int64][]*fileEntry)
+	final = make(map[string]*cluster)
+	counted = make(map[string]bool)
+	inodes = make(map[fileKey]string)
+	clusterIDs = make(map[fileKey]bool)
+	dirFiles = make(map[string]int)
+	dirBytes = make(map[string]int64)
+	metaIgnored = make(map[string]bool)
+	chunks = make(map[[sha1.Size]byte]int)
+	streamSizes = make(map[int64]bool)
+	emptyFirst, emptySum, emptyFiles = make(map[string]string), "", 0
+	phashPaths = nil
+	actions = nil
+
+	files, dupes, wasted, linked, scanned, denied = 0, 0, 0, 0, 0, 0
+	resetProgress()
+	chunkCount, chunkShared, chunkSavings = 0, 0, 0
+}
+
+// givenFlags collects the names of the options given explicitly in the
+// given flag sets.
+func givenFlags(sets ...*flag.FlagSet) map[string]bool {
+	names := make(map[string]bool)
+	for _, fs := range sets {
+		fs.Visit(func(f *flag.Flag) {
+			names[f.Name] = true
+		})
+	}
+	return names
+}
+
+// checkOptions validates options that flag.Parse can't validate for us.
+func checkOptions() error {
+	globs = strings.Split(*globbing, ",")
+	for i, pattern := range globs {
+		if *globFold {
+			globs[i] = strings.ToLower(pattern)
+		}
+		if _, err := filepath.Match(pattern, "checking pattern syntax"); err != nil {
+			return fmt.Errorf("invalid pattern %q for -g (%v)", pattern, err)
+		}
+	}
+
+	for _, pattern := range excludes {
+		if _, err := filepath.Match(pattern, "checking pattern syntax"); err != nil {
+			return fmt.Errorf("invalid pattern %q for -exclude (%v)", pattern, err)
+		}
+	}
+
+	ignoreRules = nil
+	if *ignoreFrom != "" {
+		var err error
+		ignoreRules, err = loadIgnores(*ignoreFrom)
+		if err != nil {
+			return fmt.Errorf("can't read -ignore-from file (%v)", err)
+		}
+	}
+
+	nameRegexp = nil
+	if *nameRegex != "" {
+		if *globbing != globDefault {
+			return fmt.Errorf("can't use -regex and -g together")
+		}
+		var err error
+		nameRegexp, err = regexp.Compile(*nameRegex)
+		if err != nil {
+			return fmt.Errorf("invalid regular expression for -regex (%v)", err)
+		}
+	} else if *regexPath {
+		return fmt.Errorf("can't use -regexpath without -regex")
+	}
+
+	if err := selectTypes(*fileTypes); err != nil {
+		return err
+	}
+
+	if err := selectHash(*hashName); err != nil {
+		return err
+	}
+	// crc32 and xxhash are fast but were never meant to make collisions
+	// hard (crc32 only has 32 bits to begin with), so we compare
+	// byte-by-byte unless told otherwise with -p=false
+	byteCompare = *paranoid
+	if (*hashName == "crc32" || *hashName == "xxhash") && !given["p"] && !*fromHashes {
+		byteCompare = true
+	}
+	if *useMmap && !byteCompare {
+		return fmt.Errorf("can't use -mmap without -p")
+	}
+
+	if *maximumSize < 0 || (*maximumSize > 0 && *maximumSize < *minimumSize) {
+		return fmt.Errorf("invalid size for -S (must be 0 or at least -s)")
+	}
+
+	switch *sortBy {
+	case "path", "size", "count":
+	default:
+		return fmt.Errorf("invalid order %q for -sort (must be path, size, or count)", *sortBy)
+	}
+
+	switch *keepRule {
+	case "first", "oldest", "newest", "shortest-path":
+	default:
+		return fmt.Errorf("invalid rule %q for -keep (must be first, oldest, newest, or shortest-path)", *keepRule)
+	}
+	if *keepRule != "first" && (*streaming || *countOnly) {
+		return fmt.Errorf("can't use -keep with -stream or -count-only")
+	}
+
+	if *minCount != 0 && *minCount < 2 {
+		return fmt.Errorf("invalid size for -min-count (must be at least 2)")
+	}
+
+	if *minCluster < 2 {
+		return fmt.Errorf("invalid size for -min-cluster (must be at least 2)")
+	}
+
+	if *bufferSize < 4*1024 || *bufferSize > 256<<20 {
+		return fmt.Errorf("invalid size for -bufsize (must be between 4 KB and 256 MB)")
+	}
+
+	if *prefixSize < 0 {
+		return fmt.Errorf("invalid size for -prefix (must not be negative)")
+	}
+
+	if *threshold < 0 || *threshold > 64 {
+		return fmt.Errorf("invalid number of bits for -threshold (must be between 0 and 64)")
+	}
+	if given["threshold"] && !*phash {
+		return fmt.Errorf("can't use -threshold without -phash")
+	}
+
+	if *workers < 1 {
+		return fmt.Errorf("invalid number of workers for -j (must be positive)")
+	}
+
+	if *fromHashes && *paranoid {
+		return fmt.Errorf("can't compare files byte-by-byte with -hashes-stdin")
+	}
+	if *fromHashes && (*deleting || *hardlink || *dryRun) {
+		return fmt.Errorf("can't use -delete, -hardlink, or -dry-run with -hashes-stdin (nobody checked the files)")
+	}
+	if *fromStdin && (*fromHashes || *deleting) {
+		return fmt.Errorf("can't use -from-stdin with -hashes-stdin or -delete")
+	}
+	if *dryRun && !*deleting && !*hardlink {
+		return fmt.Errorf("can't use -dry-run without -delete or -hardlink")
+	}
+	if given["action-manifest"] && *jobsFile == "" && !*deleting && !*hardlink {
+		return fmt.Errorf("can't use -action-manifest without -delete or -hardlink")
+	}
+	// these print their results and are done, they'd never get to acting
+	if (*deleting || *hardlink) && (*csvOut || *nulOut || *listDupes || *listOrigs || *statsJSON) {
+		return fmt.Errorf("can't use -delete or -hardlink with -csv, -0, -list-dupes, -list-originals, or -stats-json")
+	}
+	if *jsonOut && *csvOut {
+		return fmt.Errorf("can't use -json and -csv together")
+	}
+	if *quiet && (*jsonOut || *csvOut || *noStats) {
+		return fmt.Errorf("can't use -q with -json, -csv, or -no-stats")
+	}
+	if *listDupes && *listOrigs {
+		return fmt.Errorf("can't use -list-dupes and -list-originals together")
+	}
+	if *jsonLines && (*jsonOut || *csvOut || *quiet || *nulOut || *listDupes || *listOrigs || *streaming) {
+		return fmt.Errorf("can't use -jsonl with -json, -csv, -q, -0, -list-dupes, -list-originals, or -stream")
+	}
+	if (*listDupes || *listOrigs) && (*jsonOut || *csvOut || *quiet || *streaming) {
+		return fmt.Errorf("can't use -list-dupes or -list-originals with -json, -csv, -q, or -stream")
+	}
+	if *countOnly {
+		for _, name := range []string{"json", "jsonl", "csv", "0", "list-dupes", "list-originals", "stream", "delete", "hardlink",
+			"auto-safe-under", "redundant-dirs", "ext-summary", "link-preflight", "rsync-excludes", "revalidate", "min-cluster", "min-count", "sort"} {
+			if given[name] {
+				return fmt.Errorf("can't use -count-only and -%s together", name)
+			}
+		}
+	}
+	// -stream doesn't keep the clusters either, and its lines already
+	// went to stdout
+	if *streaming {
+		for _, name := range []string{"spill", "json", "csv", "0", "stats-json", "delete", "hardlink", "phash",
+			"auto-safe-under", "redundant-dirs", "ext-summary", "link-preflight", "rsync-excludes", "revalidate", "min-cluster", "min-count", "sort"} {
+			if given[name] {
+				return fmt.Errorf("can't use -stream and -%s together", name)
+			}
+		}
+	}
+	if *againstDir != "" {
+		if info, err := os.Stat(*againstDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("invalid directory %q for -against", *againstDir)
+		}
+		if *fromHashes || *gitDir != "" || *treeHashes || *streaming || *countOnly || *keepRule != "first" {
+			return fmt.Errorf("can't use -against with -hashes-stdin, -git, -tree-hash, -stream, -count-only, or -keep")
+		}
+	}
+	if *absPaths && *relative {
+		return fmt.Errorf("can't use -abs and -relative together")
+	}
+	if *hardlink && *deleting {
+		return fmt.Errorf("can't use -hardlink and -delete together")
+	}
+	if *hardlink && (*foldCase || *ignoreMeta) {
+		return fmt.Errorf("can't use -hardlink with -fold-case or -ignore-metadata (files differ)")
+	}
+
+	contentRegexp = nil
+	if *grepContent != "" {
+		var err error
+		contentRegexp, err = regexp.Compile(*grepContent)
+		if err != nil {
+			return fmt.Errorf("invalid regular expression for -content-match (%v)", err)
+		}
+	}
+	if *grepLimit < 1 {
+		return fmt.Errorf("invalid limit for -content-limit (must be positive)")
+	}
+
+	newerThan, olderThan = time.Time{}, time.Time{}
+	if *newerFile != "" {
+		info, err := os.Stat(*newerFile)
+		if err != nil {
+			return fmt.Errorf("invalid reference file for -newer-than-file (%v)", err)
+		}
+		newerThan = info.ModTime()
+	}
+	if *olderFile != "" {
+		info, err := os.Stat(*olderFile)
+		if err != nil {
+			return fmt.Errorf("invalid reference file for -older-than-file (%v)", err)
+		}
+		olderThan = info.ModTime()
+	}
+	// given both a time and a reference file, the stricter bound wins
+	if *newerTime != "" {
+		t, err := parseTime(*newerTime)
+		if err != nil {
+			return fmt.Errorf("invalid time %q for -newer-than (%v)", *newerTime, err)
+		}
+		if newerThan.IsZero() || t.After(newerThan) {
+			newerThan = t
+		}
+	}
+	if *olderTime != "" {
+		t, err := parseTime(*olderTime)
+		if err != nil {
+			return fmt.Errorf("invalid time %q for -older-than (%v)", *olderTime, err)
+		}
+		if olderThan.IsZero() || t.Before(olderThan) {
+			olderThan = t
+		}
+	}
+	if !newerThan.IsZero() && !olderThan.IsZero() && !newerThan.Before(olderThan) {
+		return fmt.Errorf("empty time window (-newer-than must be before -older-than)")
+	}
+
+	return nil
+}
+
+// parseTime parses the time given to -newer-than or -older-than: either a
+// duration before now, where we also allow days as in 30d, or an RFC3339
+// timestamp.
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err == nil && days >= 0 {
+			return time.Now().Add(-time.Duration(days * 24 * float64(time.Hour))), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("expected a duration like 30d or 12h, or a time like 2016-05-01T00:00:00Z")
+	}
+	return time.Now().Add(-d), nil
+}
+
+// run walks the given roots and prints the duplicates it finds as well
+// as the statistics.
+func run(args []string) {
+	roots, depths := parseRoots(args)
+
+	if *skipSpecial && !detectsSpecial && !warnedSpecial {
+		fmt.Fprintf(errOut, "warning: -skip-special-fs does nothing on %s\n", runtime.GOOS)
+		warnedSpecial = true
+	}
+
+	if *gitDir != "" {
+		runGit(roots, depths)
+		return
+	}
+
+	if *treeHashes {
+		for _, root := range roots {
+			sum, err := treeHash(root)
+			if err != nil {
+				fmt.Fprintf(errOut, "warning: issue while walking %s (%v)\n", root, err)
+				failed = true
+				continue
+			}
+			fmt.Fprintf(out, "%s  %s\n", sum, root)
+		}
+		return
+	}
+
+	if *againstDir != "" {
+		roots = append([]string{absolute(*againstDir)}, roots...)
+		depths = append([]int{unlimited}, depths...)
+	}
+
+	start := time.Now()
+
+	setupPaths(roots)
+
+	if *cacheFile != "" {
+		var err error
+		cache, err = loadCache(*cacheFile)
+		if err != nil {
+			fmt.Fprintf(errOut, "warning: can't read cache, starting over (%v)\n", err)
+			cache = newCache()
+		}
+	}
+
+	stopCatching := catchInterrupt()
+
+	if *precount {
+		visited = make(map[string]bool)
+		for i, root := range roots {
+			walkRoot, walkDepth = root, depths[i]
+			err := filepath.Walk(root, count)
+			if errors.Is(err, errInterrupted) {
+				break
+			}
+			if err != nil {
+				fmt.Fprintf(errOut, "warning: issue while counting %s (%v)\n", root, err)
+			}
+		}
+	}
+
+	if *spilling {
+		var err error
+		spill, err = newSpillStore()
+		if err != nil {
+			fmt.Fprintf(errOut, "warning: can't spill to disk, using memory (%v)\n", err)
+		}
+	}
+
+	if spill == nil && !*streaming {
+		pool = newHashPool(*workers)
+	}
+
+	// walk roots strictly one after the other; collate takes the first file
+	// found as the original, so originals come from the earliest root
+	visited = make(map[string]bool)
+	rootFiles = make([]counter, len(roots))
+	for i, root := range roots {
+		walkRoot, walkDepth = root, depths[i]
+		before := files
+		err := filepath.Walk(root, check)
+		rootFiles[i] = files - before
+		if errors.Is(err, errInterrupted) {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(errOut, "warning: issue while walking %s (%v)\n", root, err)
+			failed = true
+		}
+	}
+
+	if *fromStdin && !interrupted() {
+		if err := checkPaths(os.Stdin); err != nil {
+			fmt.Fprintf(errOut, "warning: issue while reading stdin (%v)\n", err)
+			failed = true
+		}
+	}
+
+	// hashing what's left after the walk can take longer than the walk
+	// itself, so Ctrl-C must still get us a report during that
+	if pool != nil {
+		pool.wait()
+		pool.collate()
+		pool = nil
+	}
+
+	if spill != nil {
+		err := spill.collate()
+		if err != nil {
+			fmt.Fprintf(errOut, "warning: issue while collating spilled files (%v)\n", err)
+		}
+		spill.close()
+		spill = nil
+	}
+
+	stopCatching()
+	if interrupted() {
+		failed = true
+	}
+
+	if *precount || *progress {
+		endProgress()
+	}
+
+	if cache != nil {
+		if err := cache.save(*cacheFile); err != nil {
+			fmt.Fprintf(errOut, "warning: can't write cache (%v)\n", err)
+		}
+		cache = nil
+	}
+
+	report(roots, start)
+}
+
+// report prints the duplicates we found in the given roots as well as the
+// statistics for a scan that started at the given time.
+func report(roots []string, start time.Time) {
+	if *recheck {
+		revalidate()
+	}
+
+	if *againstDir != "" {
+		restrictAgainst(roots)
+	}
+
+	keepOriginals(*keepRule)
+
+	if *minCluster > 2 {
+		dropSmallClusters(*minCluster)
+	}
+
+	if *stateFile != "" {
+		if err := dumpState(*stateFile); err != nil {
+			fmt.Fprintf(errOut, "warning: can't dump state (%v)\n", err)
+		}
+	}
+
+	if *rsyncFile != "" {
+		if err := writeRsyncExcludes(*rsyncFile, roots); err != nil {
+			fmt.Fprintf(errOut, "warning: can't write rsync excludes (%v)\n", err)
+		}
+	}
+
+	if *statsJSON {
+		if err := printStatsJSON(time.Since(start)); err != nil {
+			fmt.Fprintf(errOut, "warning: can't print statistics (%v)\n", err)
+		}
+		return
+	}
+
+	if dupes == 0 && *quietEmpty {
+		return
+	}
+
+	sk := sortedDupes()
+	if *jsonOut || *jsonLines {
+		// act first so we can report what happened; nothing but JSON
+		// may go to the output, so questions go to stderr instead
+		if *deleting || *hardlink {
+			var linkable []string
+			if *hardlink {
+				linkable, _ = linkPreflight(sk)
+			}
+			act(sk, linkable, os.Stderr, io.Discard)
+		}
+	}
+
+	if *jsonOut {
+		if err := printJSON(sk); err != nil {
+			fmt.Fprintf(errOut, "warning: can't print JSON (%v)\n", err)
+		}
+		return
+	}
+
+	if *jsonLines {
+		if err := printJSONLines(sk); err != nil {
+			fmt.Fprintf(errOut, "warning: can't print JSON (%v)\n", err)
+		}
+		return
+	}
+
+	if *csvOut {
+		if err := printCSV(sk); err != nil {
+			fmt.Fprintf(errOut, "warning: can't print CSV (%v)\n", err)
+		}
+		return
+	}
+
+	if *nulOut || *listDupes || *listOrigs {
+		end := "\n"
+		if *nulOut {
+			end = "\x00"
+		}
+		printPaths(sk, !*listDupes, !*listOrigs, end)
+		return
+	}
+
+	switch {
+	case *quiet, *countOnly:
+		// just the statistics
+	case *streaming:
+		// printed them as we went
+		fmt.Fprintln(out)
+	case *autoSafeDir != "":
+		safe, review := splitAutoSafe(sk, *autoSafeDir)
+		fmt.Fprintf(out, "# auto-safe: %v clusters\n\n", counter(len(safe)))
+		printClusters(safe, roots)
+		fmt.Fprintf(out, "# needs review: %v clusters\n\n", counter(len(review)))
+		printClusters(review, roots)
+	default:
+		printClusters(sk, roots)
+	}
+
+	if *phash && !*quiet {
+		printNearDupes()
+	}
+
+	if *redundant {
+		printRedundantDirs()
+	}
+
+	if *extReport {
+		printExtSummary()
+	}
+
+	if !*noStats {
+		if len(roots) > 1 && !*countOnly && !*streaming {
+			printRootStats(roots)
+		}
+		printStats(time.Since(start))
+	}
+
+	// -hardlink must never leave a cluster half linked, so it only
+	// touches the clusters the preflight says it can link completely
+	var linkable []string
+	if *preflight || *hardlink {
+		var skipped []string
+		linkable, skipped = linkPreflight(sk)
+		if *preflight {
+			fmt.Fprintf(out, "%v clusters could be hard linked, %v could not\n", counter(len(linkable)), counter(len(skipped)))
+		}
+	}
+
+	if *deleting || *hardlink {
+		act(sk, linkable, os.Stdout, out)
+	}
+}
+
 func main() {
+	os.Exit(realMain())
+}
+
+// writeMemProfile writes a heap profile to the file with the given name;
+// our maps are all still alive at this point, so they show up in it.
+func writeMemProfile(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	runtime.GC() // get up-to-date statistics
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// realMain does what main would do but returns the exit status instead
+// of calling os.Exit, so deferred functions get to run.
+func realMain() int {
 	flag.Usage = func() {
 		var program = os.Args[0]
 		fmt.Fprintf(os.Stderr, "Usage: %s [option...] directory...\n", program)
@@ -217,42 +1688,113 @@ func main() {
 	}
 
 	flag.Parse()
-	if len(flag.Args()) < 1 {
+	given = givenFlags(flag.CommandLine)
+	if len(flag.Args()) < 1 && *jobsFile == "" && !*fromHashes && !*fromStdin {
 		flag.Usage()
+		return exitError
 	}
 
-	_, err := filepath.Match(*globbing, "checking pattern syntax")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: invalid pattern for -g (%v)\n", err)
-		os.Exit(1)
+	if *logFile != "" {
+		closeLog, err := openLog(*logFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: can't open log file (%v)\n", err)
+			return exitError
+		}
+		defer closeLog()
+	}
+
+	if err := checkOptions(); err != nil {
+		fmt.Fprintf(errOut, "error: %v\n", err)
+		return exitError
 	}
 
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: can't create profile (%v)\n", err)
-			os.Exit(1)
+			fmt.Fprintf(errOut, "error: can't create profile (%v)\n", err)
+			return exitError
 		}
 		pprof.StartCPUProfile(f)
 		defer pprof.StopCPUProfile()
 	}
 
-	for _, root := range flag.Args() {
-		err = filepath.Walk(root, check)
+	closeOutput := func() error { return nil }
+	if *outFile != "" {
+		c, err := openOutput(*outFile)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "warning: issue while walking %s (%v)\n", root, err)
+			fmt.Fprintf(errOut, "error: can't create output file (%v)\n", err)
+			return exitError
 		}
+		closeOutput = c
 	}
 
-	sk := sortedDupes()
-	for _, k := range sk {
-		vs := final[k]
-		fmt.Println(k)
-		for _, v := range vs {
-			fmt.Println(v)
+	closeCollisions := func() error { return nil }
+	if *collisions != "" {
+		c, err := openCollisions(*collisions)
+		if err != nil {
+			closeOutput()
+			fmt.Fprintf(errOut, "error: can't create collisions file (%v)\n", err)
+			return exitError
 		}
-		fmt.Println()
+		closeCollisions = c
 	}
 
-	fmt.Printf("%v files examined, %v duplicates found, %v wasted\n", files, dupes, wasted)
+	closeManifest := func() error { return nil }
+	if *manifest != "" {
+		c, err := openManifest(*manifest)
+		if err != nil {
+			closeCollisions()
+			closeOutput()
+			fmt.Fprintf(errOut, "error: can't create action manifest (%v)\n", err)
+			return exitError
+		}
+		closeManifest = c
+	}
+
+	switch {
+	case *jobsFile != "":
+		total, err := runJobs(*jobsFile)
+		if err != nil {
+			closeOutput()
+			fmt.Fprintf(errOut, "error: can't run jobs (%v)\n", err)
+			return exitError
+		}
+		dupes = total // for the exit status, not just the last job's
+	case *fromHashes:
+		start := time.Now()
+		readHashes(os.Stdin)
+		report(nil, start)
+	default:
+		run(flag.Args())
+	}
+
+	if *memprofile != "" {
+		if err := writeMemProfile(*memprofile); err != nil {
+			fmt.Fprintf(errOut, "error: can't write heap profile (%v)\n", err)
+			failed = true
+		}
+	}
+
+	if err := closeManifest(); err != nil {
+		fmt.Fprintf(errOut, "error: can't write action manifest (%v)\n", err)
+		failed = true
+	}
+
+	if err := closeCollisions(); err != nil {
+		fmt.Fprintf(errOut, "error: can't write collisions file (%v)\n", err)
+		failed = true
+	}
+
+	if err := closeOutput(); err != nil {
+		fmt.Fprintf(errOut, "error: can't write output file (%v)\n", err)
+		return exitError
+	}
+
+	switch {
+	case failed:
+		return exitError
+	case dupes > 0:
+		return exitDupes
+	}
+	return exitClean
 }
@@ -0,0 +1,259 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// capture sends results and warnings to buffers for the rest of the test.
+func capture(t testing.TB) (results, warnings *bytes.Buffer) {
+	t.Helper()
+	results, warnings = new(bytes.Buffer), new(bytes.Buffer)
+	oldOut, oldErr := out, errOut
+	out, errOut = results, warnings
+	t.Cleanup(func() { out, errOut = oldOut, oldErr })
+	return results, warnings
+}
+
+// setFlags sets the given options for the rest of the test, as if they
+// had been given on the command line, and checks them; afterwards what
+// checkOptions derived from them is derived again from the old ones.
+func setFlags(t testing.TB, options map[string]string) error {
+	t.Helper()
+	// cleanups run last to first, so this sees the old options again
+	t.Cleanup(func() { checkOptions() })
+	oldGiven := given
+	given = make(map[string]bool)
+	for name, value := range options {
+		f := flag.Lookup(name)
+		if f == nil {
+			t.Fatalf("no option -%s", name)
+		}
+		old := f.Value.String()
+		if s, ok := f.Value.(interface{ save() func() }); ok {
+			t.Cleanup(s.save())
+		} else {
+			t.Cleanup(func() { f.Value.Set(old) })
+		}
+		if err := f.Value.Set(value); err != nil {
+			t.Fatal(err)
+		}
+		given[name] = true
+	}
+	t.Cleanup(func() { given = oldGiven })
+	return checkOptions()
+}
+
+// writeFiles creates the given files, mapping from slash-separated paths
+// relative to a new temporary directory to their contents, and returns
+// that directory.
+func writeFiles(t testing.TB, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// Files that share a long prefix but differ in a short last block must
+// not compare equal, no matter what the buffers held before.
+func TestFileContentsHelper(t *testing.T) {
+	if err := setFlags(t, map[string]string{"bufsize": "4096"}); err != nil {
+		t.Fatal(err)
+	}
+	prefix := strings.Repeat("0123456789abcdef", 3*4096/16+5)
+	tail := func(s string) string { return strings.TrimPrefix(s, prefix) }
+	for _, tc := range []struct {
+		a, b   string
+		same   bool
+		offset int64
+	}{
+		{prefix + "tail", prefix + "tail", true, 0},
+		{prefix + "tail", prefix + "taIl", false, int64(len(prefix)) + 2},
+		{prefix + "tail", prefix + "tai", false, int64(len(prefix)) + 3},
+		{prefix, prefix + "tail", false, int64(len(prefix))},
+		{"", "", true, 0},
+		{"x", "", false, 0},
+	} {
+		// HalfReader makes sure short reads don't confuse us either
+		same, offset, err := fileContentsHelper(iotest.HalfReader(strings.NewReader(tc.a)), strings.NewReader(tc.b))
+		if err != nil || same != tc.same || offset != tc.offset {
+			t.Errorf("comparing ...%q with ...%q got %v at %d (%v), want %v at %d",
+				tail(tc.a), tail(tc.b), same, offset, err, tc.same, tc.offset)
+		}
+	}
+}
+
+// The exit status says whether there were duplicates, or trouble.
+func TestExitStatus(t *testing.T) {
+	capture(t)
+	clean := writeFiles(t, map[string]string{"a": "one", "b": "two"})
+	dirty := writeFiles(t, map[string]string{"a": "same", "b": "same"})
+	// the usage message goes straight to stderr
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldArgs, oldUsage, oldStderr := os.Args, flag.Usage, os.Stderr
+	os.Stderr = null
+	t.Cleanup(func() {
+		os.Args, flag.Usage, os.Stderr, failed = oldArgs, oldUsage, oldStderr, false
+		null.Close()
+	})
+
+	for _, tc := range []struct {
+		args []string
+		want int
+	}{
+		{[]string{clean}, exitClean},
+		{[]string{dirty}, exitDupes},
+		{[]string{clean, dirty}, exitDupes},
+		{[]string{filepath.Join(clean, "missing")}, exitError},
+		{[]string{dirty, filepath.Join(clean, "missing")}, exitError},
+		{nil, exitError},
+	} {
+		os.Args = append([]string{"dupes"}, tc.args...)
+		reset()
+		failed = false
+		if got := realMain(); got != tc.want {
+			t.Errorf("dupes %v exited with %d, want %d", tc.args, got, tc.want)
+		}
+	}
+}
+
+// A directory we can't read is skipped with a warning, the rest of the
+// root is still scanned.
+func TestPermissionDeniedDirectory(t *testing.T) {
+	_, warnings := capture(t)
+	dir := writeFiles(t, map[string]string{"a/1": "same", "locked/2": "same", "z/3": "same"})
+	locked := filepath.Join(dir, "locked")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(locked, 0777)
+	if _, err := os.ReadDir(locked); err == nil {
+		t.Skip("permissions aren't enforced here")
+	}
+
+	reset()
+	failed = false
+	run([]string{dir})
+	if files != 2 || dupes != 1 || denied != 1 || failed {
+		t.Errorf("got %v files, %v duplicates, %v denied, failed %v, want 2, 1, 1, false", files, dupes, denied, failed)
+	}
+	if !bytes.Contains(warnings.Bytes(), []byte(locked)) {
+		t.Errorf("no warning about %s in %q", locked, warnings)
+	}
+}
+
+// Permission errors are counted and the walk goes on; other errors end
+// it.
+func TestUnreadable(t *testing.T) {
+	capture(t)
+	reset()
+	denial := &fs.PathError{Op: "open", Path: "x", Err: fs.ErrPermission}
+	if err := unreadable("x", denial); err != nil || denied != 1 {
+		t.Errorf("permission error returned %v with %v denied, want nil with 1", err, denied)
+	}
+	other := errors.New("disk on fire")
+	if err := unreadable("x", other); err != other || denied != 1 {
+		t.Errorf("other error returned %v with %v denied, want %v with 1", err, denied, other)
+	}
+}
+
+// Scanning the same tree twice prints the same thing twice, byte for
+// byte, however the hash workers get scheduled.
+func TestOutputIsReproducible(t *testing.T) {
+	results, _ := capture(t)
+	tree := make(map[string]string)
+	for i := 0; i < 60; i++ {
+		tree[fmt.Sprintf("%c/%d", 'a'+i%5, i)] = strings.Repeat("x", i%6+1)
+	}
+	dir := writeFiles(t, tree)
+	if err := setFlags(t, map[string]string{"no-stats": "true", "j": "8"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var outputs []string
+	for i := 0; i < 2; i++ {
+		results.Reset()
+		reset()
+		run([]string{dir})
+		outputs = append(outputs, results.String())
+	}
+	if outputs[0] == "" {
+		t.Fatal("no output")
+	}
+	if outputs[0] != outputs[1] {
+		t.Errorf("first scan printed\n%s\nsecond scan printed\n%s", outputs[0], outputs[1])
+	}
+}
+
+// With -q and -no-summary-on-empty, a clean tree prints nothing and one
+// with duplicates just the statistics.
+func TestQuietNoSummaryOnEmpty(t *testing.T) {
+	for name, files := range map[string]map[string]string{
+		"clean": {"a": "one", "b": "two"},
+		"dupes": {"a": "one", "b": "one"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			results, _ := capture(t)
+			dir := writeFiles(t, files)
+			if err := setFlags(t, map[string]string{"q": "true", "no-summary-on-empty": "true"}); err != nil {
+				t.Fatal(err)
+			}
+			reset()
+			run([]string{dir})
+
+			got := results.String()
+			switch {
+			case name == "clean" && got != "":
+				t.Errorf("got %q, want nothing", got)
+			case name == "dupes" && (strings.Contains(got, dir) || !strings.Contains(got, "1 duplicates")):
+				t.Errorf("got %q, want just the statistics", got)
+			}
+		})
+	}
+}
+
+// Comparing large identical files byte-by-byte, as -p does, with
+// different -bufsize settings; bigger buffers mean fewer system calls.
+func BenchmarkFileContentsMatch(b *testing.B) {
+	const size = 16 << 20
+	contents := strings.Repeat("0123456789abcdef", size/16)
+	dir := writeFiles(b, map[string]string{"a": contents, "b": contents})
+	pa, pb := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+
+	for _, bufsize := range []string{"4096", "65536", "1048576", "8388608"} {
+		b.Run(bufsize, func(b *testing.B) {
+			if err := setFlags(b, map[string]string{"bufsize": bufsize}); err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(2 * size)
+			for i := 0; i < b.N; i++ {
+				if same, _, err := fileContentsMatch(pa, pb); err != nil || !same {
+					b.Fatalf("got %v (%v), want the same", same, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import "fmt"
+
+var (
+	emptyFirst = make(map[string]string) // maps from collate keys to the first empty file (only for -include-empty)
+	emptySum   string                    // digest of no bytes at all, the same for all empty files
+	emptyFiles counter                   // number of empty files examined
+)
+
+// recordEmpty records the empty file with the given path. All empty files
+// are the same, so there's nothing to hash: the first one is the original
+// of a single cluster and all others are its duplicates; -same-name and
+// -same-dir split that cluster up just like any other. We never open any
+// of them, not even for -p.
+func recordEmpty(path string) error {
+	emptyFiles++
+	if emptySum == "" {
+		emptySum = fmt.Sprintf("%x", newHash().Sum(nil))
+	}
+	key := collateKey(path, emptySum)
+	first, ok := emptyFirst[key]
+	if !ok {
+		emptyFirst[key] = path
+		return nil
+	}
+	before := dupes
+	if err := recordDupe(path, first, emptySum, 0); err != nil {
+		return err
+	}
+	if *streaming && dupes > before {
+		fmt.Fprintf(out, "%s\t%s\n", first, path)
+	}
+	return nil
+}
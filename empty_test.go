@@ -0,0 +1,51 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Empty files all end up in one cluster without a single one of them
+// being hashed.
+func TestEmptyFilesArentHashed(t *testing.T) {
+	capture(t)
+	tree := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		tree[fmt.Sprintf("%02d", i)] = ""
+	}
+	dir := writeFiles(t, tree)
+	if err := setFlags(t, map[string]string{"include-empty": "true", "v": "true"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// -v says what it hashes, straight to stderr
+	log, err := os.Create(filepath.Join(t.TempDir(), "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	oldStderr := os.Stderr
+	os.Stderr = log
+	reset()
+	run([]string{dir})
+	os.Stderr = oldStderr
+
+	verbose, err := os.ReadFile(log.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(verbose), "hashed "); n != 0 {
+		t.Errorf("hashed %d files, want none", n)
+	}
+	c, ok := final[filepath.Join(dir, "00")]
+	if !ok || len(final) != 1 || len(c.duplicates) != 99 || dupes != 99 {
+		t.Errorf("got %v duplicates in clusters %v, want 99 in one", dupes, originals())
+	}
+}
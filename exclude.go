@@ -0,0 +1,132 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// patternList is a flag that can be given more than once; each time adds
+// another pattern to the list.
+type patternList []string
+
+func (p *patternList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patternList) Set(pattern string) error {
+	*p = append(*p, pattern)
+	return nil
+}
+
+// save returns a function that restores the current list; setting the
+// flag to its old string value would append instead.
+func (p *patternList) save() func() {
+	saved := append(patternList(nil), *p...)
+	return func() { *p = saved }
+}
+
+// excludes are the patterns given with -exclude.
+var excludes patternList
+
+func init() {
+	flag.Var(&excludes, "exclude", "glob expression for file and directory names to skip (repeatable)")
+}
+
+// ignoreRule is a pattern from the file given with -ignore-from.
+type ignoreRule struct {
+	pattern  string // glob expression, with / as the separator
+	anchored bool   // match the path relative to the root, not just the name
+	dirOnly  bool   // only match directories
+}
+
+// ignoreRules are the rules read from the -ignore-from file.
+var ignoreRules []ignoreRule
+
+// loadIgnores reads the rules in the file with the given path, one per
+// line, in a simplified .gitignore syntax: empty lines and lines starting
+// with # are skipped, a trailing / only matches directories, and a pattern
+// with a / anywhere else is matched against the whole path relative to
+// the root instead of just the name.
+func loadIgnores(path string) ([]ignoreRule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if strings.HasPrefix(text, "!") {
+			return nil, fmt.Errorf("line %d: negated patterns aren't supported", line)
+		}
+		var r ignoreRule
+		if strings.HasSuffix(text, "/") {
+			r.dirOnly = true
+			text = strings.TrimSuffix(text, "/")
+		}
+		if strings.Contains(text, "/") {
+			r.anchored = true
+			text = strings.TrimPrefix(text, "/")
+		}
+		if _, err := filepath.Match(text, "checking pattern syntax"); err != nil {
+			return nil, fmt.Errorf("line %d: invalid pattern %q (%v)", line, text, err)
+		}
+		r.pattern = text
+		rules = append(rules, r)
+	}
+	return rules, scanner.Err()
+}
+
+// ignored checks whether the file or directory with the given path and
+// info matches one of the -ignore-from rules.
+func ignored(path string, info os.FileInfo) bool {
+	var rel string
+	for _, r := range ignoreRules {
+		if r.dirOnly && !info.IsDir() {
+			continue
+		}
+		name := info.Name()
+		if r.anchored {
+			if rel == "" {
+				rel, _ = filepath.Rel(walkRoot, path)
+				rel = filepath.ToSlash(rel)
+			}
+			name = rel
+		}
+		if matched, _ := filepath.Match(r.pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// excluded checks whether the file or directory with the given path and
+// info matches one of the -exclude patterns or -ignore-from rules, or is
+// hidden and we were told to skip those.
+func excluded(path string, info os.FileInfo) bool {
+	name := info.Name()
+	if *noHidden && strings.HasPrefix(name, ".") {
+		return true
+	}
+	for _, pattern := range excludes {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return ignored(path, info)
+}
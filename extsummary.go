@@ -0,0 +1,77 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// extStat is how many duplicates with one extension we found and how
+// much space they waste.
+type extStat struct {
+	ext    string
+	dupes  counter
+	wasted bytesize
+}
+
+// extSummary adds up the duplicates in all clusters by their lowercased
+// extension, the ones wasting the most space first.
+func extSummary() []extStat {
+	byExt := make(map[string]*extStat)
+	for _, c := range final {
+		for _, d := range c.duplicates {
+			ext := strings.ToLower(filepath.Ext(d))
+			if ext == "" {
+				ext = "(none)"
+			}
+			s, ok := byExt[ext]
+			if !ok {
+				s = &extStat{ext: ext}
+				byExt[ext] = s
+			}
+			s.dupes++
+			s.wasted += bytesize(c.size)
+		}
+	}
+
+	stats := make([]extStat, 0, len(byExt))
+	for _, s := range byExt {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].wasted != stats[j].wasted {
+			return stats[i].wasted > stats[j].wasted
+		}
+		return stats[i].ext < stats[j].ext
+	})
+	return stats
+}
+
+// printExtSummary prints the space wasted by duplicates for each
+// extension as a table.
+func printExtSummary() {
+	stats := extSummary()
+	ew, dw, ww := 0, 0, 0
+	for _, s := range stats {
+		if n := utf8.RuneCountInString(s.ext); n > ew {
+			ew = n
+		}
+		if n := len(s.dupes.String()); n > dw {
+			dw = n
+		}
+		if n := len(s.wasted.String()); n > ww {
+			ww = n
+		}
+	}
+	fmt.Fprintln(out, "# wasted by extension")
+	for _, s := range stats {
+		fmt.Fprintf(out, "%-*s  %*v duplicates  %*v\n", ew, s.ext, dw, s.dupes, ww, s.wasted)
+	}
+	fmt.Fprintln(out)
+}
@@ -0,0 +1,14 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+//go:build !unix
+
+package main
+
+import "os"
+
+// fileID always fails; we only know how to get at inodes on Unix.
+func fileID(info os.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
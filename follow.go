@@ -0,0 +1,57 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// visited holds the directories we've entered with -L, so symlinks that
+// point back up the tree (or anywhere we've been) don't send us around
+// in circles.
+var visited map[string]bool
+
+// dirKey identifies the directory with the given path and info no matter
+// how we got there: by device and inode where we can, by its path with
+// all symlinks resolved elsewhere.
+func dirKey(path string, info os.FileInfo) string {
+	if id, ok := fileID(info); ok {
+		return fmt.Sprintf("%d:%d", id.dev, id.ino)
+	}
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+	return canonical(path)
+}
+
+// firstVisit checks whether this is the first time we enter the directory
+// with the given path and info.
+func firstVisit(path string, info os.FileInfo) bool {
+	k := dirKey(path, info)
+	if visited[k] {
+		return false
+	}
+	visited[k] = true
+	return true
+}
+
+// followLink calls the given walk function for whatever the symlink with
+// the given path points to, as if it was right there; for a directory,
+// that means walking all of it. Broken links are reported and skipped.
+func followLink(path string, walkFn filepath.WalkFunc) error {
+	target, err := os.Stat(path)
+	if err != nil {
+		fmt.Fprintf(errOut, "warning: can't follow %s (%v)\n", path, err)
+		return nil
+	}
+	if !target.IsDir() {
+		return walkFn(path, target, nil)
+	}
+	// with a trailing separator Walk looks at the directory the link
+	// points to instead of the link itself
+	return filepath.Walk(path+string(filepath.Separator), walkFn)
+}
@@ -0,0 +1,166 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// gitRepo is what we know about the history of a git repository.
+type gitRepo struct {
+	dir    string            // where the repository is
+	sha256 bool              // does it use SHA-256 object names?
+	blobs  map[string]string // maps from blob names to paths in history
+}
+
+// gitOutput runs git in the given directory and returns its output.
+func gitOutput(dir string, args ...string) ([]byte, error) {
+	return runCommand(exec.Command("git", append([]string{"-C", dir}, args...)...))
+}
+
+// runCommand runs the given command and returns its output. Whatever it
+// says on stderr ends up in the error, or in a warning if it worked
+// anyway, so it goes wherever -log sends them.
+func runCommand(cmd *exec.Cmd) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	msg := strings.TrimSpace(stderr.String())
+	switch {
+	case msg == "":
+	case err != nil:
+		err = fmt.Errorf("%v: %s", err, msg)
+	default:
+		fmt.Fprintf(errOut, "warning: %s says %s\n", filepath.Base(cmd.Path), msg)
+	}
+	return output, err
+}
+
+// loadGitRepo collects all blobs reachable from any ref in the git
+// repository in the given directory, along with a path each had.
+func loadGitRepo(dir string) (*gitRepo, error) {
+	format, err := gitOutput(dir, "rev-parse", "--show-object-format")
+	if err != nil {
+		return nil, err
+	}
+	objects, err := gitOutput(dir, "rev-list", "--objects", "--all")
+	if err != nil {
+		return nil, err
+	}
+	// rev-list doesn't say which objects are blobs, cat-file does
+	cmd := exec.Command("git", "-C", dir, "cat-file", "--batch-check=%(objectname) %(objecttype) %(rest)")
+	cmd.Stdin = bytes.NewReader(objects)
+	typed, err := runCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := &gitRepo{
+		dir:    dir,
+		sha256: strings.TrimSpace(string(format)) == "sha256",
+		blobs:  make(map[string]string),
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(typed))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) == 3 && fields[1] == "blob" {
+			repo.blobs[fields[0]] = fields[2]
+		}
+	}
+	return repo, scanner.Err()
+}
+
+// blobName calculates the name git would give the contents of the file
+// with the given path and size.
+func (r *gitRepo) blobName(path string, size int64) (string, error) {
+	file, err := openRegular(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var hasher hash.Hash
+	if r.sha256 {
+		hasher = sha256.New()
+	} else {
+		hasher = sha1.New()
+	}
+	fmt.Fprintf(hasher, "blob %d\x00", size)
+	_, err = io.Copy(hasher, file)
+	return fmt.Sprintf("%x", hasher.Sum(nil)), err
+}
+
+// commitOf finds a commit that introduced the blob with the given name.
+func (r *gitRepo) commitOf(blob string) string {
+	out, err := gitOutput(r.dir, "log", "--all", "--format=%H", "--find-object="+blob)
+	if err != nil {
+		return "?"
+	}
+	commits := strings.Fields(string(out))
+	if len(commits) == 0 {
+		return "?"
+	}
+	return commits[len(commits)-1]
+}
+
+// runGit walks the given roots, each down to the given depth, and prints
+// all files whose contents are also a blob somewhere in the history of the
+// git repository. The walk selects files just like the usual one does.
+func runGit(roots []string, depths []int) {
+	repo, err := loadGitRepo(*gitDir)
+	if err != nil {
+		fmt.Fprintf(errOut, "error: can't read git repository %s (%v)\n", *gitDir, err)
+		failed = true
+		return
+	}
+
+	setupPaths(roots)
+	visited = make(map[string]bool)
+	rootFiles = make([]counter, len(roots))
+
+	var found counter
+	var walk filepath.WalkFunc
+	walk = func(path string, info os.FileInfo, err error) error {
+		if ok, err := filter(path, info, err, walk); !ok {
+			return err
+		}
+		files++
+
+		name, err := repo.blobName(path, info.Size())
+		if err != nil {
+			return unreadable(path, err)
+		}
+		old, ok := repo.blobs[name]
+		if !ok {
+			return nil
+		}
+		found++
+		fmt.Fprintln(out, display(path, roots))
+		fmt.Fprintf(out, "\tblob %s %s in commit %s\n\n", name, old, repo.commitOf(name))
+		return nil
+	}
+	for i, root := range roots {
+		walkRoot, walkDepth = root, depths[i]
+		before := files
+		err := filepath.Walk(root, walk)
+		rootFiles[i] = files - before
+		if err != nil {
+			fmt.Fprintf(errOut, "warning: issue while walking %s (%v)\n", root, err)
+			failed = true
+		}
+	}
+
+	fmt.Fprintf(out, "%v files examined, %v found in git history (experimental)\n", files, found)
+}
@@ -0,0 +1,55 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// -git selects files like the usual walk does, and -relative applies to
+// what it prints.
+func TestGitFilters(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := writeFiles(t, map[string]string{"old": "history"})
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "old"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "old"},
+	} {
+		if output, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", args[0], err, output)
+		}
+	}
+
+	dir := writeFiles(t, map[string]string{
+		"found":         "history",
+		"skipped/found": "history",
+		".hidden":       "history",
+		"deep/er/found": "history",
+	})
+	results, warnings := capture(t)
+	if err := setFlags(t, map[string]string{
+		"git":       repo,
+		"exclude":   "skipped",
+		"no-hidden": "true",
+		"relative":  "true",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	reset()
+	run([]string{dir + "=1"})
+
+	if failed {
+		t.Fatalf("failed: %s", warnings)
+	}
+	got := results.String()
+	if !strings.HasPrefix(got, "found\n") || !strings.Contains(got, "1 files examined, 1 found") {
+		t.Errorf("got %q, want just found", got)
+	}
+}
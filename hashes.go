@@ -0,0 +1,78 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// parseHashLine splits a "hash size path" line into its parts; the path
+// is everything after the second space so it may contain spaces itself.
+func parseHashLine(line string) (sum string, size int64, path string, err error) {
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) != 3 || fields[0] == "" || fields[2] == "" {
+		return "", 0, "", fmt.Errorf("expected \"hash size path\"")
+	}
+	size, err = strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return "", 0, "", fmt.Errorf("invalid size %q", fields[1])
+	}
+	return fields[0], size, fields[2], nil
+}
+
+// readHashes collates the "hash size path" lines read from the given
+// reader the same way check collates files, except that it never looks
+// at the files themselves: the first path with a given hash and size is
+// the original, later ones are its duplicates. Malformed lines are
+// reported and skipped.
+func readHashes(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == "" {
+			continue
+		}
+		sum, size, path, err := parseHashLine(text)
+		if err != nil {
+			fmt.Fprintf(errOut, "warning: skipping line %d (%v)\n", line, err)
+			continue
+		}
+		path = absolute(path)
+
+		if size < *minimumSize || (*maximumSize > 0 && size > *maximumSize) {
+			continue
+		}
+		if *globbing != globDefault && !globMatch(filepath.Base(path)) {
+			continue
+		}
+		if nameRegexp != nil && !regexMatch(path, filepath.Base(path)) {
+			continue
+		}
+		if typeExts != nil && !typeMatch(filepath.Base(path)) {
+			continue
+		}
+
+		files++
+		scanned += bytesize(size)
+
+		key := collateKey(path, fmt.Sprintf("%s/%d", sum, size))
+		dupe, ok := hashes[key]
+		if !ok {
+			hashes[key] = path
+			continue
+		}
+		recordDupe(path, dupe, sum, size)
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(errOut, "warning: issue while reading stdin (%v)\n", err)
+	}
+}
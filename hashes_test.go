@@ -0,0 +1,71 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Nothing checked the files behind the lines read by -hashes-stdin, so
+// nothing may touch them either.
+func TestHashesStdinRejectsActions(t *testing.T) {
+	for _, action := range []map[string]string{
+		{"hashes-stdin": "true", "delete": "true"},
+		{"hashes-stdin": "true", "hardlink": "true"},
+		{"hashes-stdin": "true", "hardlink": "true", "dry-run": "true"},
+	} {
+		t.Run("", func(t *testing.T) {
+			if err := setFlags(t, action); err == nil {
+				t.Errorf("%v accepted", action)
+			}
+		})
+	}
+}
+
+// -hashes-stdin must not look at the files, not even to find hard links.
+func TestHashesStdinDoesntStat(t *testing.T) {
+	capture(t)
+	dir := writeFiles(t, map[string]string{"p": "aaa"})
+	p, q := filepath.Join(dir, "p"), filepath.Join(dir, "q")
+	if err := os.Link(p, q); err != nil {
+		t.Skip("no hard links here:", err)
+	}
+	if err := setFlags(t, map[string]string{"hashes-stdin": "true"}); err != nil {
+		t.Fatal(err)
+	}
+	reset()
+
+	readHashes(strings.NewReader("deadbeef 3 " + p + "\ndeadbeef 3 " + q + "\n"))
+	if dupes != 1 || linked != 0 {
+		t.Errorf("got %v duplicates and %v hard links, want 1 and 0", dupes, linked)
+	}
+}
+
+// The hashes that aren't cryptographic turn on byte-by-byte comparison,
+// unless told otherwise.
+func TestWeakHashesCompareBytes(t *testing.T) {
+	for _, tc := range []struct {
+		options map[string]string
+		want    bool
+	}{
+		{map[string]string{"hash": "sha256"}, false},
+		{map[string]string{"hash": "crc32"}, true},
+		{map[string]string{"hash": "xxhash"}, true},
+		{map[string]string{"hash": "crc32", "p": "false"}, false},
+		{map[string]string{"hash": "sha256", "p": "true"}, true},
+	} {
+		t.Run("", func(t *testing.T) {
+			if err := setFlags(t, tc.options); err != nil {
+				t.Fatal(err)
+			}
+			if byteCompare != tc.want {
+				t.Errorf("%v compares byte-by-byte: %v, want %v", tc.options, byteCompare, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+)
+
+// errInterrupted is what check returns once Ctrl-C was pressed, so the
+// walk stops where it is.
+var errInterrupted = errors.New("interrupted")
+
+// stopping is set (atomically) once Ctrl-C was pressed.
+var stopping int32
+
+// interrupted checks whether Ctrl-C was pressed.
+func interrupted() bool {
+	return atomic.LoadInt32(&stopping) != 0
+}
+
+// catchInterrupt makes Ctrl-C stop the walk instead of the program, so
+// we still report the duplicates found so far; pressing it again quits
+// right away as usual. Call the returned function once hashing is done.
+func catchInterrupt() func() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	go func() {
+		if _, ok := <-c; !ok {
+			return
+		}
+		signal.Stop(c)
+		atomic.StoreInt32(&stopping, 1)
+		fmt.Fprintln(os.Stderr, "\ninterrupted, reporting what we found so far (press Ctrl-C again to quit right away)")
+	}()
+	return func() {
+		signal.Stop(c)
+		close(c)
+	}
+}
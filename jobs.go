@@ -0,0 +1,103 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// jobFlags returns a flag set for parsing the options of a single job.
+// It shares its values with the command line flags but leaves out the
+// ones that only make sense once per process.
+func jobFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("job", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	flag.VisitAll(func(f *flag.Flag) {
+		switch f.Name {
+		case "jobs-file", "o", "log", "collisions", "action-manifest", "cpuprofile", "memprofile":
+			return
+		}
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	return fs
+}
+
+// runJobs runs each job listed in the file with the given path. Between
+// jobs the options are restored to what the command line said and all
+// state is reset. Broken jobs are reported and skipped. It returns the
+// number of duplicates found by all jobs.
+func runJobs(path string) (counter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var defaults []func()
+	flag.VisitAll(func(f *flag.Flag) {
+		if s, ok := f.Value.(interface{ save() func() }); ok {
+			defaults = append(defaults, s.save())
+			return
+		}
+		// not flag.Set, which would count the option as given explicitly
+		v, value := f.Value, f.Value.String()
+		defaults = append(defaults, func() { v.Set(value) })
+	})
+
+	var jobs, totalFiles, totalDupes counter
+	var totalWasted bytesize
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() && !interrupted() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		for _, restore := range defaults {
+			restore()
+		}
+		reset()
+
+		fs := jobFlags()
+		if err := fs.Parse(strings.Fields(text)); err != nil {
+			fmt.Fprintf(errOut, "warning: skipping job on line %d (%v)\n", line, err)
+			continue
+		}
+		given = givenFlags(flag.CommandLine, fs)
+		if err := checkOptions(); err != nil {
+			fmt.Fprintf(errOut, "warning: skipping job on line %d (%v)\n", line, err)
+			continue
+		}
+		if fs.NArg() < 1 {
+			fmt.Fprintf(errOut, "warning: skipping job on line %d (no paths)\n", line)
+			continue
+		}
+
+		if jobs > 0 {
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintf(out, "job %d: %s\n\n", jobs+1, text)
+		run(fs.Args())
+
+		jobs++
+		totalFiles += files
+		totalDupes += dupes
+		totalWasted += wasted
+	}
+	if err := scanner.Err(); err != nil {
+		return totalDupes, err
+	}
+
+	fmt.Fprintf(out, "\n%v jobs, %v files examined, %v duplicates found, %v wasted\n", jobs, totalFiles, totalDupes, totalWasted)
+	return totalDupes, nil
+}
@@ -0,0 +1,42 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Restoring the defaults between jobs must not make options look given
+// explicitly; that used to skip every job with "can't use -threshold
+// without -phash" and kept -hash xxhash from turning on -p.
+func TestJobsDefaultsNotGiven(t *testing.T) {
+	results, warnings := capture(t)
+	dir := writeFiles(t, map[string]string{"a": "same", "b": "same", "c": "other"})
+	jobsFile := filepath.Join(t.TempDir(), "jobs")
+	jobs := "-hash sha1 " + dir + "\n-hash xxhash " + dir + "\n"
+	if err := os.WriteFile(jobsFile, []byte(jobs), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	total, err := runJobs(jobsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(warnings.String(), "skipping job") {
+		t.Fatalf("jobs skipped: %s", warnings)
+	}
+	if total != 2 {
+		t.Errorf("got %v duplicates in all jobs, want 2\n%s", total, results)
+	}
+	if given["threshold"] || given["p"] || given["count-only"] {
+		t.Errorf("defaults counted as given: %v", given)
+	}
+	if !byteCompare {
+		t.Errorf("-hash xxhash in a job didn't turn on byte-by-byte comparison")
+	}
+}
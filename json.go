@@ -0,0 +1,155 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"encoding/json"
+)
+
+// jsonCluster is how a cluster looks in -json output. With -delete or
+// -hardlink it also says which files were kept and what happened to the
+// others.
+type jsonCluster struct {
+	Original   string       `json:"original"`
+	Duplicates []string     `json:"duplicates"`
+	Size       int64        `json:"size"`
+	Hash       string       `json:"hash"`
+	Kept       []string     `json:"kept,omitempty"`
+	Actions    []jsonAction `json:"actions,omitempty"`
+	Reclaimed  *int64       `json:"bytesReclaimed,omitempty"`
+}
+
+// jsonAction is what -delete or -hardlink did to one duplicate: its
+// status is "removed", "linked", "would remove", "would link", or
+// "failed" along with an error.
+type jsonAction struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// jsonSummary is how the statistics look in -json output; numbers are
+// raw integers.
+type jsonSummary struct {
+	FilesExamined   uint64             `json:"filesExamined"`
+	DuplicatesFound uint64             `json:"duplicatesFound"`
+	BytesWasted     uint64             `json:"bytesWasted"`
+	Actions         *jsonActionSummary `json:"actions,omitempty"`
+}
+
+// jsonActionSummary adds up what -delete or -hardlink did.
+type jsonActionSummary struct {
+	DryRun         bool   `json:"dryRun"`
+	Succeeded      uint64 `json:"succeeded"`
+	Failed         uint64 `json:"failed"`
+	BytesReclaimed uint64 `json:"bytesReclaimed"`
+}
+
+// summary returns the statistics as they look in -json output.
+func summary() jsonSummary {
+	s := jsonSummary{
+		FilesExamined:   uint64(files),
+		DuplicatesFound: uint64(dupes),
+		BytesWasted:     uint64(wasted),
+	}
+	if *deleting || *hardlink {
+		s.Actions = &jsonActionSummary{DryRun: *dryRun}
+		for _, a := range actions {
+			if a.err != nil {
+				s.Actions.Failed++
+				continue
+			}
+			s.Actions.Succeeded++
+			s.Actions.BytesReclaimed += uint64(a.size)
+		}
+	}
+	return s
+}
+
+// newJSONCluster returns the cluster with the given original as it looks
+// in -json output; results maps from originals to what -delete or
+// -hardlink did to their duplicates.
+func newJSONCluster(k string, results map[string][]actionResult) jsonCluster {
+	c := jsonCluster{
+		Original:   k,
+		Duplicates: final[k].duplicates,
+		Size:       final[k].size,
+		Hash:       final[k].sum,
+	}
+	if !*deleting && !*hardlink {
+		return c
+	}
+
+	done := make(map[string]bool)
+	var reclaimed int64
+	c.Actions = []jsonAction{}
+	for _, a := range results[k] {
+		if a.err != nil {
+			c.Actions = append(c.Actions, jsonAction{Path: a.path, Status: "failed", Error: a.err.Error()})
+			continue
+		}
+		c.Actions = append(c.Actions, jsonAction{Path: a.path, Status: a.what})
+		reclaimed += a.size
+		done[a.path] = true
+	}
+	c.Reclaimed = &reclaimed
+	// kept are the files we left alone (or would, with -dry-run)
+	for _, m := range final[k].members() {
+		if !done[m] {
+			c.Kept = append(c.Kept, m)
+		}
+	}
+	return c
+}
+
+// actionsByOriginal groups what -delete or -hardlink did by cluster.
+func actionsByOriginal() map[string][]actionResult {
+	results := make(map[string][]actionResult)
+	for _, a := range actions {
+		results[a.original] = append(results[a.original], a)
+	}
+	return results
+}
+
+// printJSON prints the clusters with the given originals and the
+// statistics as a single JSON document.
+func printJSON(originals []string) error {
+	doc := struct {
+		Clusters []jsonCluster `json:"clusters"`
+		Summary  jsonSummary   `json:"summary"`
+	}{
+		Clusters: []jsonCluster{},
+		Summary:  summary(),
+	}
+	results := actionsByOriginal()
+	for _, k := range originals {
+		doc.Clusters = append(doc.Clusters, newJSONCluster(k, results))
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "\t")
+	return enc.Encode(doc)
+}
+
+// printJSONLines prints the clusters with the given originals as one
+// JSON object per line, each tagged with "type":"cluster", followed by
+// the statistics tagged with "type":"summary".
+func printJSONLines(originals []string) error {
+	enc := json.NewEncoder(out)
+	results := actionsByOriginal()
+	for _, k := range originals {
+		line := struct {
+			Type string `json:"type"`
+			jsonCluster
+		}{"cluster", newJSONCluster(k, results)}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+	return enc.Encode(struct {
+		Type string `json:"type"`
+		jsonSummary
+	}{"summary", summary()})
+}
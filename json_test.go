@@ -0,0 +1,40 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// With -delete or -hardlink each cluster says what was kept and what
+// happened to each duplicate, failures included.
+func TestJSONActionResults(t *testing.T) {
+	if err := setFlags(t, map[string]string{"hardlink": "true", "json": "true"}); err != nil {
+		t.Fatal(err)
+	}
+	reset()
+	final["a"] = &cluster{original: "a", duplicates: []string{"b", "c", "d"}, size: 10, sum: "f00"}
+	acted("linked", "b", "a", nil)
+	acted("linked", "c", "a", errors.New("no space"))
+
+	got := newJSONCluster("a", actionsByOriginal())
+	if want := []string{"a", "c", "d"}; !reflect.DeepEqual(got.Kept, want) {
+		t.Errorf("kept %v, want %v", got.Kept, want)
+	}
+	want := []jsonAction{{Path: "b", Status: "linked"}, {Path: "c", Status: "failed", Error: "no space"}}
+	if !reflect.DeepEqual(got.Actions, want) {
+		t.Errorf("actions %v, want %v", got.Actions, want)
+	}
+	if got.Reclaimed == nil || *got.Reclaimed != 10 {
+		t.Errorf("reclaimed %v, want 10", got.Reclaimed)
+	}
+
+	s := summary()
+	if s.Actions == nil || s.Actions.Succeeded != 1 || s.Actions.Failed != 1 || s.Actions.BytesReclaimed != 10 {
+		t.Errorf("summary %+v, want 1 succeeded, 1 failed, 10 bytes reclaimed", s.Actions)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// better checks whether the file with path a and modification time ma
+// makes a better original than the one with path b and time mb under the
+// given rule; ties go to b, the one we found first.
+func better(rule string, a string, ma time.Time, b string, mb time.Time) bool {
+	switch rule {
+	case "oldest":
+		return ma.Before(mb)
+	case "newest":
+		return ma.After(mb)
+	case "shortest-path":
+		return len(a) < len(b)
+	}
+	return false
+}
+
+// keepOriginals picks a new original for every cluster according to the
+// given rule; the original is the file -delete and -hardlink leave alone.
+// Files we can't stat anymore never become the original. Duplicates are
+// still in the order we found them, so ties go to the one found first.
+func keepOriginals(rule string) {
+	if rule == "first" {
+		return
+	}
+	for _, k := range originals() {
+		c := final[k]
+		members := c.members()
+
+		best := -1
+		var bestTime time.Time
+		for i, m := range members {
+			var mtime time.Time
+			if rule != "shortest-path" {
+				info, err := os.Lstat(m)
+				if err != nil {
+					fmt.Fprintf(errOut, "warning: can't consider %s for -keep (%v)\n", m, err)
+					continue
+				}
+				mtime = info.ModTime()
+			}
+			if best < 0 || better(rule, m, mtime, members[best], bestTime) {
+				best, bestTime = i, mtime
+			}
+		}
+		if best <= 0 {
+			continue
+		}
+
+		delete(final, k)
+		duplicates := append([]string{c.original}, c.duplicates[:best-1]...)
+		duplicates = append(duplicates, c.duplicates[best:]...)
+		c = &cluster{original: members[best], duplicates: duplicates, size: c.size, sum: c.sum}
+		final[c.original] = c
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Ties go to the file found first, not to the smallest path.
+func TestKeepTiesGoToFirstFound(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"d/1": "x", "c/2": "x", "b/3": "x"})
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for name, mtime := range map[string]time.Time{"d/1": old.Add(time.Hour), "c/2": old, "b/3": old} {
+		if err := os.Chtimes(filepath.Join(dir, name), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := func(name string) string { return filepath.Join(dir, filepath.FromSlash(name)) }
+
+	reset()
+	final[path("d/1")] = &cluster{original: path("d/1"), duplicates: []string{path("c/2"), path("b/3")}, size: 1}
+	keepOriginals("oldest")
+
+	c, ok := final[path("c/2")]
+	if !ok || len(final) != 1 {
+		t.Fatalf("got clusters %v, want one with original %s", originals(), path("c/2"))
+	}
+	if want := []string{path("d/1"), path("b/3")}; len(c.duplicates) != 2 || c.duplicates[0] != want[0] || c.duplicates[1] != want[1] {
+		t.Errorf("got duplicates %v, want %v", c.duplicates, want)
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// clusterIDs holds the device and inode numbers of all files in clusters
+// so far; on Unix we use them to notice hard links.
+var clusterIDs = make(map[fileKey]bool)
+
+// alreadyLinked checks whether the file with the given path is a hard link
+// to the given original or to another file in its cluster. Hard links
+// don't waste any space, so they aren't duplicates. Where we can't get
+// at inodes we can at least compare with the original.
+func alreadyLinked(path, original string) bool {
+	pi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	oi, err := os.Stat(original)
+	if err != nil {
+		return false
+	}
+	if os.SameFile(pi, oi) {
+		return true
+	}
+
+	id, ok := fileID(pi)
+	if !ok {
+		return false
+	}
+	if oid, ok := fileID(oi); ok {
+		clusterIDs[oid] = true
+	}
+	if clusterIDs[id] {
+		return true
+	}
+	clusterIDs[id] = true
+	return false
+}
+
+// deviceOf returns the device of the file with the given info, if we can
+// tell; it's a variable so tests can pretend we can't.
+var deviceOf = func(info os.FileInfo) (uint64, bool) {
+	id, ok := fileID(info)
+	return id.dev, ok
+}
+
+// sameDevice checks whether all the given paths are on the same
+// filesystem (device), which hard links require. Where we can't tell,
+// say because we can't get at devices on this system, we assume they
+// are; linking will fail soon enough if they aren't.
+func sameDevice(paths []string) (bool, error) {
+	var dev uint64
+	known := false
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return false, err
+		}
+		d, ok := deviceOf(info)
+		switch {
+		case !ok:
+			continue
+		case !known:
+			dev, known = d, true
+		case d != dev:
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// linkPreflight splits the given clusters into those that could be hard
+// linked to their original and those that can't, usually because some
+// duplicates are on a different filesystem. It must run before we link
+// anything so we never end up with a half-linked cluster.
+func linkPreflight(originals []string) (linkable, skipped []string) {
+	for _, k := range originals {
+		same, err := sameDevice(final[k].members())
+		switch {
+		case err != nil:
+			fmt.Fprintf(errOut, "warning: can't link cluster of %s (%v)\n", k, err)
+			skipped = append(skipped, k)
+		case !same:
+			fmt.Fprintf(errOut, "warning: can't link cluster of %s (spans filesystems)\n", k)
+			skipped = append(skipped, k)
+		default:
+			linkable = append(linkable, k)
+		}
+	}
+	return linkable, skipped
+}
+
+// hardlinkDupes replaces each duplicate in the clusters of the given
+// originals with a hard link to its original; the clusters should have
+// passed linkPreflight, so we don't leave any of them half linked.
+// Duplicates that still can't be linked are left alone. With -dry-run we
+// only say what we would do. What we did goes to w.
+func hardlinkDupes(originals []string, w io.Writer) {
+	var created counter
+	var saved bytesize
+	what := "linked"
+	if *dryRun {
+		what = "would link"
+	}
+
+	for _, k := range originals {
+		for _, d := range final[k].duplicates {
+			done, err := linkOver(k, d)
+			if err != nil || done {
+				acted(what, d, k, err)
+			}
+			switch {
+			case errors.Is(err, syscall.EXDEV):
+				fmt.Fprintf(errOut, "warning: can't link %s to %s (different filesystems)\n", d, k)
+			case err != nil:
+				fmt.Fprintf(errOut, "warning: can't link %s to %s (%v)\n", d, k, err)
+			case done:
+				if *dryRun {
+					fmt.Fprintf(w, "would link %s -> %s\n", d, k)
+				}
+				created++
+				saved += bytesize(final[k].size)
+			}
+		}
+	}
+
+	if *dryRun {
+		fmt.Fprintf(w, "%v links would be created, %v would be saved\n", created, saved)
+		return
+	}
+	fmt.Fprintf(w, "%v links created, %v saved\n", created, saved)
+}
+
+// linkOver replaces the file at path with a hard link to original unless
+// it already is one. The link is made under a temporary name first and
+// then renamed, so there's always a file at path. With -dry-run we only
+// check whether we could, as far as we can tell without trying.
+func linkOver(original, path string) (bool, error) {
+	oi, err := os.Stat(original)
+	if err != nil {
+		return false, err
+	}
+	pi, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if os.SameFile(oi, pi) {
+		return false, nil
+	}
+
+	if *dryRun {
+		oid, ok := fileID(oi)
+		pid, pok := fileID(pi)
+		if ok && pok && oid.dev != pid.dev {
+			return false, syscall.EXDEV
+		}
+		return true, nil
+	}
+
+	tmp := path + ".dupes-link"
+	if err := os.Link(original, tmp); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	return true, nil
+}
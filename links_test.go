@@ -0,0 +1,106 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// -hardlink must skip clusters that span filesystems entirely instead of
+// linking the files it can.
+func TestHardlinkSkipsClustersAcrossFilesystems(t *testing.T) {
+	capture(t)
+	here := writeFiles(t, map[string]string{"a": "same", "b": "same"})
+	there, err := os.MkdirTemp("/dev/shm", "dupes")
+	if err != nil {
+		t.Skip("no second filesystem:", err)
+	}
+	defer os.RemoveAll(there)
+	if err := os.WriteFile(filepath.Join(there, "c"), []byte("same"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if same, err := sameDevice([]string{here, there}); err != nil || same {
+		t.Skip("no second filesystem")
+	}
+
+	if err := setFlags(t, map[string]string{"hardlink": "true", "yes": "true"}); err != nil {
+		t.Fatal(err)
+	}
+	reset()
+	run([]string{here, there})
+	a, b := filepath.Join(here, "a"), filepath.Join(here, "b")
+
+	ai, _ := os.Stat(a)
+	bi, _ := os.Stat(b)
+	if os.SameFile(ai, bi) {
+		t.Errorf("linked %s to %s in a cluster that spans filesystems", b, a)
+	}
+}
+
+// With -skip-linked a second link to a file is neither hashed nor counted
+// as a duplicate, only as a link.
+func TestSkipLinked(t *testing.T) {
+	capture(t)
+	dir := writeFiles(t, map[string]string{"a": "same"})
+	if err := os.Link(filepath.Join(dir, "a"), filepath.Join(dir, "b")); err != nil {
+		t.Skip("no hard links here:", err)
+	}
+	if err := setFlags(t, map[string]string{"skip-linked": "true"}); err != nil {
+		t.Fatal(err)
+	}
+	reset()
+	run([]string{dir})
+
+	if files != 2 || dupes != 0 || wasted != 0 || linked != 1 || len(final) != 0 {
+		t.Errorf("got %v files, %v duplicates, %v wasted, %v hard links, want 2, 0, 0 bytes, 1", files, dupes, wasted, linked)
+	}
+	if hashedBytes != 0 {
+		t.Errorf("hashed %d bytes, want none", hashedBytes)
+	}
+}
+
+// A hard link to a file in a cluster doesn't waste any space, so it's
+// counted as a link rather than as a duplicate.
+func TestHardLinksAreNotDuplicates(t *testing.T) {
+	capture(t)
+	dir := writeFiles(t, map[string]string{"a": "same", "c": "same"})
+	if err := os.Link(filepath.Join(dir, "a"), filepath.Join(dir, "b")); err != nil {
+		t.Skip("no hard links here:", err)
+	}
+	reset()
+	run([]string{dir})
+
+	if dupes != 1 || wasted != 4 || linked != 1 {
+		t.Errorf("got %v duplicates, %v wasted, %v hard links, want 1, 4 bytes, 1", dupes, wasted, linked)
+	}
+	c, ok := final[filepath.Join(dir, "a")]
+	if !ok || len(c.duplicates) != 1 || c.duplicates[0] != filepath.Join(dir, "c") {
+		t.Errorf("got clusters %v, want c as the only duplicate of a", originals())
+	}
+}
+
+// Where we can't tell which device a file is on, -hardlink tries anyway
+// instead of skipping every cluster.
+func TestHardlinkUnknownDevice(t *testing.T) {
+	capture(t)
+	dir := writeFiles(t, map[string]string{"a": "same", "b": "same"})
+	old := deviceOf
+	deviceOf = func(os.FileInfo) (uint64, bool) { return 0, false }
+	defer func() { deviceOf = old }()
+
+	if err := setFlags(t, map[string]string{"hardlink": "true", "yes": "true"}); err != nil {
+		t.Fatal(err)
+	}
+	reset()
+	run([]string{dir})
+
+	ai, _ := os.Stat(filepath.Join(dir, "a"))
+	bi, _ := os.Stat(filepath.Join(dir, "b"))
+	if !os.SameFile(ai, bi) {
+		t.Error("didn't link b to a")
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// errOut is where warnings and errors go: stderr, or the -log file.
+var errOut io.Writer = os.Stderr
+
+// verbosef prints a message to stderr, but only with -v. The workers
+// call it too; each message is a single write, so lines don't mix.
+func verbosef(format string, args ...interface{}) {
+	if !*verbose {
+		return
+	}
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
+// logWriter writes to a log file, starting each line with a timestamp.
+type logWriter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func (l *logWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var buf bytes.Buffer
+	stamp := time.Now().Format(time.RFC3339)
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(line) > 0 {
+			fmt.Fprintf(&buf, "%s %s", stamp, line)
+		}
+	}
+	if _, err := l.file.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// openLog makes warnings and errors go to the file with the given path,
+// appending to what's already there, instead of stderr. The function it
+// returns puts stderr back and closes the file.
+func openLog(path string) (func() error, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	errOut = &logWriter{file: f}
+
+	return func() error {
+		errOut = os.Stderr
+		return f.Close()
+	}, nil
+}
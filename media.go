@@ -0,0 +1,230 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// metaIgnored records the originals of clusters we found by ignoring
+// metadata (only for -ignore-metadata).
+var metaIgnored = make(map[string]bool)
+
+// Media formats we can strip metadata from.
+const (
+	mediaNone = ""
+	mediaJPEG = "JPEG"
+	mediaPNG  = "PNG"
+	mediaMP3  = "MP3"
+)
+
+var (
+	magicJPEG = []byte{0xff, 0xd8, 0xff}
+	magicPNG  = []byte("\x89PNG\r\n\x1a\n")
+	magicID3  = []byte("ID3")
+
+	errMedia = errors.New("malformed media file")
+)
+
+// pngMetadata lists the PNG chunks that only carry metadata.
+var pngMetadata = map[string]bool{
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"eXIf": true,
+	"tIME": true,
+}
+
+// mediaKind figures out which media format the given file is in, if any,
+// by looking at its first few bytes.
+func mediaKind(file io.ReaderAt) string {
+	head := make([]byte, len(magicPNG))
+	n, _ := file.ReadAt(head, 0)
+	head = head[:n]
+	switch {
+	case bytes.HasPrefix(head, magicJPEG):
+		return mediaJPEG
+	case bytes.HasPrefix(head, magicPNG):
+		return mediaPNG
+	case bytes.HasPrefix(head, magicID3):
+		return mediaMP3
+	case mpegFrame(head):
+		return mediaMP3 // MPEG audio frame without ID3 tag
+	}
+	return mediaNone
+}
+
+// mpegFrame checks whether the given bytes start with a valid MPEG audio
+// frame header: frame sync, and none of the version, layer, bitrate, or
+// sampling rate fields set to a reserved value. Lots of binary files
+// start with 0xFF, the frame sync alone is no proof of anything.
+func mpegFrame(head []byte) bool {
+	if len(head) < 4 || head[0] != 0xff || head[1]&0xe0 != 0xe0 {
+		return false
+	}
+	version := head[1] >> 3 & 0x3
+	layer := head[1] >> 1 & 0x3
+	bitrate := head[2] >> 4
+	sampling := head[2] >> 2 & 0x3
+	return version != 1 && layer != 0 && bitrate != 0xf && sampling != 3
+}
+
+// section is a range of bytes in a file.
+type section struct {
+	off, len int64
+}
+
+// mediaPayload returns a reader for the actual image or audio data of the
+// given file with the given size, skipping metadata: APPn and COM segments
+// in JPEG, text, EXIF, and time chunks in PNG, ID3 tags in MP3. If the file
+// isn't in a format we know, the second result is false.
+func mediaPayload(file io.ReaderAt, size int64) (io.Reader, bool, error) {
+	var sections []section
+	var err error
+
+	switch mediaKind(file) {
+	case mediaJPEG:
+		sections, err = jpegSections(file, size)
+	case mediaPNG:
+		sections, err = pngSections(file, size)
+	case mediaMP3:
+		sections, err = mp3Sections(file, size)
+	default:
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var readers []io.Reader
+	for _, s := range sections {
+		readers = append(readers, io.NewSectionReader(file, s.off, s.len))
+	}
+	return io.MultiReader(readers...), true, nil
+}
+
+// jpegSections finds everything but APPn and COM segments in a JPEG file.
+// Once we hit the start of scan, the rest of the file is image data.
+func jpegSections(file io.ReaderAt, size int64) ([]section, error) {
+	sections := []section{{0, 2}} // SOI marker
+	off := int64(2)
+	marker := make([]byte, 4)
+	for off < size {
+		if _, err := file.ReadAt(marker, off); err != nil {
+			return nil, errMedia
+		}
+		if marker[0] != 0xff {
+			return nil, errMedia
+		}
+		kind := marker[1]
+		length := int64(binary.BigEndian.Uint16(marker[2:])) + 2
+		if kind == 0xda { // start of scan
+			sections = append(sections, section{off, size - off})
+			break
+		}
+		if !(kind >= 0xe0 && kind <= 0xef || kind == 0xfe) {
+			sections = append(sections, section{off, length})
+		}
+		off += length
+	}
+	if off > size { // truncated
+		return nil, errMedia
+	}
+	return sections, nil
+}
+
+// pngSections finds everything but metadata chunks in a PNG file.
+func pngSections(file io.ReaderAt, size int64) ([]section, error) {
+	sections := []section{{0, int64(len(magicPNG))}}
+	off := int64(len(magicPNG))
+	header := make([]byte, 8)
+	for off < size {
+		if _, err := file.ReadAt(header, off); err != nil {
+			return nil, errMedia
+		}
+		length := int64(binary.BigEndian.Uint32(header)) + 12 // length, type, and CRC
+		if !pngMetadata[string(header[4:])] {
+			sections = append(sections, section{off, length})
+		}
+		off += length
+	}
+	if off > size { // truncated
+		return nil, errMedia
+	}
+	return sections, nil
+}
+
+// mp3Sections finds the audio data between ID3v2 and ID3v1 tags.
+func mp3Sections(file io.ReaderAt, size int64) ([]section, error) {
+	start, end := int64(0), size
+
+	header := make([]byte, 10)
+	if _, err := file.ReadAt(header, 0); err == nil && bytes.HasPrefix(header, magicID3) {
+		// tag size is "synchsafe", 7 bits per byte
+		tag := int64(header[6])<<21 | int64(header[7])<<14 | int64(header[8])<<7 | int64(header[9])
+		start = 10 + tag
+		if header[5]&0x10 != 0 { // footer present
+			start += 10
+		}
+	}
+
+	trailer := make([]byte, 3)
+	if end-128 >= start {
+		if _, err := file.ReadAt(trailer, end-128); err == nil && string(trailer) == "TAG" {
+			end -= 128
+		}
+	}
+
+	if start > end {
+		return nil, errMedia
+	}
+	return []section{{start, end - start}}, nil
+}
+
+// checkMedia is what check does for media files with -ignore-metadata:
+// since metadata can differ in size, we can't rule anything out by file
+// size and have to go straight to comparing digests of the payload.
+func checkMedia(path string, size int64) error {
+	sum, err := digest(path)
+	if err != nil {
+		return err
+	}
+	key := collateKey(path, "media:"+sum)
+
+	dupe, ok := hashes[key]
+	if !ok {
+		hashes[key] = path
+		return nil
+	}
+	metaIgnored[dupe] = true
+	return recordDupe(path, dupe, sum, size)
+}
+
+// isMedia checks whether the file with the given path is in one of the
+// media formats we know. A file that only looks like one, say because it
+// got truncated, isn't; we warn about it and compare all of it.
+func isMedia(path string) (bool, error) {
+	file, err := openRegular(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	if mediaKind(file) == mediaNone {
+		return false, nil
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	if _, _, err := mediaPayload(file, info.Size()); err != nil {
+		fmt.Fprintf(errOut, "warning: comparing all of %s (%v)\n", path, err)
+		return false, nil
+	}
+	return true, nil
+}
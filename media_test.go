@@ -0,0 +1,56 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// A truncated JPEG is compared as a whole, with a warning, and doesn't
+// end the walk.
+func TestIgnoreMetadataMalformedMedia(t *testing.T) {
+	_, warnings := capture(t)
+	bad := "\xff\xd8\xff\xe0\x01\x00JFIF" // APP0 segment longer than the file
+	dir := writeFiles(t, map[string]string{
+		"a/bad.jpg": bad, "a/x": "same", "b/bad.jpg": bad, "b/x": "same",
+	})
+	if err := setFlags(t, map[string]string{"ignore-metadata": "true"}); err != nil {
+		t.Fatal(err)
+	}
+	reset()
+	failed = false
+	run([]string{dir})
+
+	if files != 4 || dupes != 2 || failed {
+		t.Errorf("got %v files and %v duplicates, failed %v, want 4, 2, false", files, dupes, failed)
+	}
+	if !strings.Contains(warnings.String(), "bad.jpg") {
+		t.Errorf("no warning about bad.jpg in %q", warnings)
+	}
+}
+
+// Only an ID3 tag or a valid frame header makes a file an MP3, not just
+// any 0xFF 0xEx at the start.
+func TestMediaKindMP3(t *testing.T) {
+	for _, tc := range []struct {
+		head string
+		want string
+	}{
+		{"ID3\x04\x00\x00\x00\x00\x00\x00", mediaMP3},
+		{"\xff\xfb\x90\x64\x00\x00\x00\x00", mediaMP3}, // MPEG-1 layer III, 128 kbit/s, 44.1 kHz
+		{"\xff\xe0\x00\x00\x00\x00\x00\x00", mediaNone},
+		{"\xff\xfb\xf0\x64\x00\x00\x00\x00", mediaNone}, // bad bitrate
+		{"\xff\xfb\x9c\x64\x00\x00\x00\x00", mediaNone}, // bad sampling rate
+		{"\xff\xeb\x90\x64\x00\x00\x00\x00", mediaNone}, // reserved version
+		{"\xff\xf9\x90\x64\x00\x00\x00\x00", mediaNone}, // reserved layer
+		{"\xff\xfb", mediaNone},
+	} {
+		if got := mediaKind(bytes.NewReader([]byte(tc.head))); got != tc.want {
+			t.Errorf("% x got %q, want %q", tc.head, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+//go:build !unix
+
+package main
+
+// mmapMatch always fails; we only know how to map files on Unix, so
+// fileContentsMatch falls back on reading them.
+func mmapMatch(pa, pb string) (same bool, offset int64, ok bool) {
+	return false, 0, false
+}
@@ -0,0 +1,54 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+//go:build unix
+
+package main
+
+import (
+	"bytes"
+	"math"
+	"syscall"
+)
+
+// mmapMatch compares the files with the given paths by mapping both into
+// memory; if either can't be mapped, say because it's empty or too large
+// for our address space, it says so and fileContentsMatch falls back on
+// reading them.
+func mmapMatch(pa, pb string) (same bool, offset int64, ok bool) {
+	a, err := mmapFile(pa)
+	if err != nil {
+		return false, 0, false
+	}
+	defer syscall.Munmap(a)
+	b, err := mmapFile(pb)
+	if err != nil {
+		return false, 0, false
+	}
+	defer syscall.Munmap(b)
+
+	if bytes.Equal(a, b) {
+		return true, 0, true
+	}
+	return false, firstDifference(a, b), true
+}
+
+// mmapFile maps the whole file with the given path into memory, read-only.
+func mmapFile(path string) ([]byte, error) {
+	file, err := openRegular(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() // the mapping stays valid
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size <= 0 || size > math.MaxInt {
+		return nil, syscall.EINVAL
+	}
+	return syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
@@ -0,0 +1,52 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// out is where all results go; warnings and errors go to stderr.
+var out io.Writer = os.Stdout
+
+// collisionOut is where hash collisions found with -p are reported.
+var collisionOut io.Writer = os.Stderr
+
+// openOutput makes all results go to a new file with the given path
+// instead of stdout. The function it returns writes out whatever is
+// still buffered and closes the file; its error is the first one that
+// happened while writing, if any.
+func openOutput(path string) (func() error, error) {
+	return redirect(&out, path)
+}
+
+// openCollisions makes collision reports go to a new file with the given
+// path instead of stderr; see openOutput.
+func openCollisions(path string) (func() error, error) {
+	return redirect(&collisionOut, path)
+}
+
+// redirect points the given writer at a new file with the given path
+// until the function it returns puts it back; see openOutput.
+func redirect(dst *io.Writer, path string) (func() error, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	old := *dst
+	w := bufio.NewWriter(f)
+	*dst = w
+
+	return func() error {
+		*dst = old
+		err := w.Flush()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,171 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // register decoders for image.Decode
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"path/filepath"
+	"strings"
+)
+
+// phashSide is the side of the grid we shrink images to; each of the
+// phashSide*phashSide cells gives us one bit of the perceptual hash.
+const phashSide = 8
+
+// phashExts holds the extensions of the image formats we can decode.
+var phashExts = map[string]bool{
+	"jpg":  true,
+	"jpeg": true,
+	"png":  true,
+	"gif":  true,
+}
+
+// phashPaths holds the paths of all images examined, in the order we
+// found them (only for -phash).
+var phashPaths []string
+
+// phashImage checks whether the file with the given name is an image we
+// can compute a perceptual hash for.
+func phashImage(name string) bool {
+	return phashExts[strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))]
+}
+
+// averageHash computes the "average hash" of the image in the file with
+// the given path: we shrink the image to 8x8 gray cells and set a bit for
+// each cell brighter than the average. Re-encoding or resizing an image
+// hardly changes it, so similar images have hashes that differ in only a
+// few bits.
+func averageHash(path string) (uint64, error) {
+	file, err := openRegular(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return 0, err
+	}
+
+	b := img.Bounds()
+	if b.Empty() {
+		return 0, fmt.Errorf("empty image")
+	}
+	var cells [phashSide * phashSide]float64
+	var mean float64
+	for j := 0; j < phashSide; j++ {
+		y0, y1 := cellRange(b.Min.Y, b.Dy(), j)
+		for i := 0; i < phashSide; i++ {
+			x0, x1 := cellRange(b.Min.X, b.Dx(), i)
+			var sum float64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					sum += float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+				}
+			}
+			cell := sum / float64((x1-x0)*(y1-y0))
+			cells[j*phashSide+i] = cell
+			mean += cell
+		}
+	}
+	mean /= phashSide * phashSide
+
+	var h uint64
+	for k, cell := range cells {
+		if cell > mean {
+			h |= 1 << uint(k)
+		}
+	}
+	return h, nil
+}
+
+// cellRange returns the pixels [from, to) that the given cell covers along
+// an axis starting at min with the given length; every cell covers at
+// least one pixel, even in tiny images.
+func cellRange(min, length, cell int) (from, to int) {
+	from = min + cell*length/phashSide
+	to = min + (cell+1)*length/phashSide
+	if to <= from {
+		to = from + 1
+	}
+	if to > min+length {
+		from, to = min+length-1, min+length
+	}
+	return from, to
+}
+
+// nearCluster is a group of images whose perceptual hashes are within
+// -threshold bits of the first one's.
+type nearCluster struct {
+	hash     uint64
+	paths    []string
+	distance []int // bits each path's hash differs from the first one's
+}
+
+// nearDupes groups the images we examined by perceptual hash. Images that
+// are exact duplicates are left out, their originals stand in for them.
+// Like everywhere else, the first image of a group is the one we found
+// first; a later image joins the first group that's close enough.
+func nearDupes() []*nearCluster {
+	exact := make(map[string]bool)
+	for _, c := range final {
+		for _, d := range c.duplicates {
+			exact[d] = true
+		}
+	}
+
+	var groups []*nearCluster
+	for _, path := range phashPaths {
+		if exact[path] {
+			continue
+		}
+		h, err := averageHash(path)
+		if err != nil {
+			fmt.Fprintf(errOut, "warning: can't compute perceptual hash of %s (%v)\n", path, err)
+			continue
+		}
+		joined := false
+		for _, g := range groups {
+			if d := bits.OnesCount64(g.hash ^ h); d <= *threshold {
+				g.paths = append(g.paths, path)
+				g.distance = append(g.distance, d)
+				joined = true
+				break
+			}
+		}
+		if !joined {
+			groups = append(groups, &nearCluster{hash: h, paths: []string{path}, distance: []int{0}})
+		}
+	}
+
+	var near []*nearCluster
+	for _, g := range groups {
+		if len(g.paths) > 1 {
+			near = append(near, g)
+		}
+	}
+	return near
+}
+
+// printNearDupes prints the groups of near-duplicate images, separately
+// from the exact duplicates.
+func printNearDupes() {
+	near := nearDupes()
+	fmt.Fprintln(out, "# near-duplicate images (experimental)")
+	for _, g := range near {
+		fmt.Fprintln(out, g.paths[0])
+		for i, path := range g.paths[1:] {
+			fmt.Fprintf(out, "%s (%d bits apart)\n", path, g.distance[i+1])
+		}
+		fmt.Fprintln(out)
+	}
+	fmt.Fprintf(out, "# %v near-duplicate clusters\n\n", counter(len(near)))
+}
@@ -0,0 +1,197 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"sync"
+)
+
+// fileEntry is a file that shares its size with at least one other file.
+// Each of its digests is calculated at most once, by whichever worker
+// gets it.
+type fileEntry struct {
+	path   string
+	size   int64
+	prefix string // digest of the first -prefix bytes, for large files
+	sum    string // digest of the whole file
+	err    error  // error while hashing, if any
+}
+
+// needsPrefix checks whether we should look at the prefix of the given
+// entry before hashing all of it.
+func (e *fileEntry) needsPrefix() bool {
+	return *prefixSize > 0 && e.size > *prefixSize && e.prefix == ""
+}
+
+// buckets maps from sizes to all files of that size, in the order we
+// found them; only sizes with more than one file are in here, all others
+// are only in sizes
+var buckets = make(map[int64][]*fileEntry)
+
+// hashPool is a pool of goroutines that calculate digests concurrently
+// while the walk goes on.
+type hashPool struct {
+	entries chan *fileEntry
+	wg      sync.WaitGroup
+}
+
+// pool is the hash pool for the current scan.
+var pool *hashPool
+
+// newHashPool starts a pool with the given number of workers.
+func newHashPool(workers int) *hashPool {
+	p := &hashPool{
+		entries: make(chan *fileEntry, workers),
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// work calculates digests until there are no more entries: the prefix
+// digest if the entry needs one, otherwise the full digest. Each entry
+// goes to one worker only, and nobody looks at it before wait, so there's
+// no need for a lock. Once Ctrl-C was pressed, entries are left alone;
+// collation skips entries without a digest.
+func (p *hashPool) work() {
+	defer p.wg.Done()
+	for e := range p.entries {
+		if interrupted() {
+			continue
+		}
+		if e.needsPrefix() {
+			if cache != nil {
+				if sum, ok := cache.cachedSum(e.path); ok {
+					e.sum, e.err = addXattr(e.path, sum)
+					continue
+				}
+			}
+			e.prefix, e.err = prefixChecksum(e.path, *prefixSize)
+		} else {
+			e.sum, e.err = digest(e.path)
+		}
+	}
+}
+
+// submit hands the given entry to one of the workers.
+func (p *hashPool) submit(e *fileEntry) {
+	p.entries <- e
+}
+
+// wait waits for the workers to finish all digests submitted so far; we
+// can't submit anything after that.
+func (p *hashPool) wait() {
+	close(p.entries)
+	p.wg.Wait()
+}
+
+// collate goes through the digests the workers calculated and records
+// duplicates.
+func (p *hashPool) collate() {
+	collateBuckets(buckets)
+}
+
+// hashEntries calculates the next digest for each of the given entries
+// with a new pool. Submitting waits for a free worker, so this is also a
+// good place to keep the progress line going.
+func hashEntries(es []*fileEntry) {
+	if len(es) == 0 {
+		return
+	}
+	p := newHashPool(*workers)
+	for i, e := range es {
+		if interrupted() {
+			break
+		}
+		if *precount || *progress {
+			showHashing(e.path, counter(i+1), counter(len(es)))
+		}
+		p.submit(e)
+	}
+	p.wait()
+}
+
+// collateBuckets records the duplicates in the given buckets. Entries
+// that haven't been hashed yet are hashed first. Large files whose prefix
+// digests differ can't be duplicates, so only those sharing a prefix
+// digest with another file of the same size get their full digest.
+//
+// Workers finish in no particular order, so we go by the order in which
+// the walk found the files: within each size the first file with a given
+// digest is the original, later ones are duplicates. That way the results
+// don't depend on the number of workers.
+//
+// Most files of a given size still have a digest nobody else has; we
+// don't keep those around in hashes, only the ones with duplicates.
+func collateBuckets(bs map[int64][]*fileEntry) {
+	var ss []int64
+	var pending []*fileEntry
+	for size, es := range bs {
+		ss = append(ss, size)
+		for _, e := range es {
+			if e.err == nil && e.sum == "" && e.prefix == "" {
+				pending = append(pending, e)
+			}
+		}
+	}
+	sort.Slice(ss, func(i, j int) bool { return ss[i] < ss[j] })
+	hashEntries(pending)
+
+	// files whose digest came from the cache have no prefix digest, and
+	// any file of their size could match them
+	pending = nil
+	for _, size := range ss {
+		prefixes := make(map[string]int)
+		cached := false
+		for _, e := range bs[size] {
+			switch {
+			case e.err != nil:
+			case e.prefix != "":
+				prefixes[e.prefix]++
+			case e.sum != "":
+				cached = true
+			}
+		}
+		for _, e := range bs[size] {
+			if e.err == nil && e.sum == "" && (cached || prefixes[e.prefix] > 1) {
+				pending = append(pending, e)
+			}
+		}
+	}
+	hashEntries(pending)
+
+	for _, size := range ss {
+		originals := make(map[string]string)
+		for _, e := range bs[size] {
+			if e.err != nil {
+				fmt.Fprintf(errOut, "warning: can't hash %s (%v)\n", e.path, e.err)
+				if errors.Is(e.err, fs.ErrPermission) {
+					denied++
+				}
+				continue
+			}
+			if e.sum == "" {
+				continue // prefix is unique
+			}
+			key := collateKey(e.path, e.sum)
+			dupe, ok := originals[key]
+			if !ok {
+				originals[key] = e.path
+				continue
+			}
+			hashes[key] = dupe
+			if err := recordDupe(e.path, dupe, e.sum, size); err != nil {
+				fmt.Fprintf(errOut, "warning: can't compare %s (%v)\n", e.path, err)
+			}
+		}
+		delete(bs, size) // nobody needs the entries anymore
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// However many files share a size, each is hashed at most once.
+func TestEachFileHashedOnce(t *testing.T) {
+	capture(t)
+	dir := writeFiles(t, map[string]string{
+		"a": "12345678", "b": "12345678",
+		"c": "abcdefgh", "d": "abcdefgh",
+		"e": "unique!!",
+	})
+
+	reset()
+	run([]string{dir})
+	if n := atomic.LoadInt64(&hashedBytes); n != 5*8 {
+		t.Errorf("hashed %d bytes, want %d", n, 5*8)
+	}
+	if dupes != 2 || len(final) != 2 {
+		t.Errorf("got %v duplicates in %d clusters, want 2 in 2", dupes, len(final))
+	}
+}
+
+// peakHeap scans the given directory and returns how many more bytes
+// were on the heap at most during the scan than before it; it's only
+// sampled every millisecond, so it's an estimate.
+func peakHeap(dir string) uint64 {
+	var m runtime.MemStats
+	reset()
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	base, peak := m.HeapAlloc, m.HeapAlloc
+
+	done := make(chan bool)
+	sampled := make(chan bool)
+	go func() {
+		var m runtime.MemStats
+		for {
+			runtime.ReadMemStats(&m)
+			if m.HeapAlloc > peak {
+				peak = m.HeapAlloc
+			}
+			select {
+			case <-done:
+				sampled <- true
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}()
+	run([]string{dir})
+	done <- true
+	<-sampled
+	return peak - base
+}
+
+// Files with a size nobody else has are never hashed, so they should only
+// cost us an entry in sizes; compare the peak heap per file for a tree of
+// such files with one where all files share a size and have to be hashed.
+func BenchmarkScanHeap(b *testing.B) {
+	capture(b)
+	for _, tc := range []struct {
+		name string
+		size func(i int) int
+	}{
+		{"unique sizes", func(i int) int { return i + 1 }},
+		{"same size", func(i int) int { return 64 }},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			const n = 2000
+			tree := make(map[string]string)
+			for i := 0; i < n; i++ {
+				contents := fmt.Sprintf("%08d", i)
+				tree[fmt.Sprintf("%02d/%d", i%50, i)] = strings.Repeat(contents, tc.size(i)/8+1)[:tc.size(i)]
+			}
+			dir := writeFiles(b, tree)
+			b.ResetTimer()
+
+			var heap uint64
+			for i := 0; i < b.N; i++ {
+				heap += peakHeap(dir)
+			}
+			b.ReportMetric(float64(heap)/float64(b.N)/n, "peak-heap-B/file")
+		})
+	}
+}
+
+// Once Ctrl-C was pressed, whatever wasn't hashed yet stays that way and
+// collation just skips it.
+func TestCollateAfterInterrupt(t *testing.T) {
+	capture(t)
+	dir := writeFiles(t, map[string]string{"a": "same", "b": "same"})
+	atomic.StoreInt32(&stopping, 1)
+	defer atomic.StoreInt32(&stopping, 0)
+
+	reset()
+	collateBuckets(map[int64][]*fileEntry{4: {
+		{path: filepath.Join(dir, "a"), size: 4},
+		{path: filepath.Join(dir, "b"), size: 4},
+	}})
+	if n := atomic.LoadInt64(&hashedBytes); n != 0 || dupes != 0 {
+		t.Errorf("hashed %d bytes and found %v duplicates, want none", n, dupes)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// progressInterval is how often we update the progress line at most.
+	progressInterval = 200 * time.Millisecond
+	// logInterval is how often we print a progress line at most if
+	// stderr isn't a terminal, say because it goes to a log file.
+	logInterval = 10 * time.Second
+	// pathWidth is how much of the current path we show at most.
+	pathWidth = 40
+)
+
+var (
+	totalFiles  counter   // number of files found by the pre-count
+	totalBytes  bytesize  // space (in bytes) occupied by those files
+	doneBytes   bytesize  // space (in bytes) occupied by files examined so far
+	hashedBytes int64     // bytes hashed so far, updated atomically by the workers
+	lastShown   time.Time // when we last updated the progress line
+)
+
+// hashed accounts for n more bytes hashed; it's safe to call from
+// several goroutines.
+func hashed(n int64) {
+	atomic.AddInt64(&hashedBytes, n)
+}
+
+// terminal checks whether stderr is a terminal, in which case we can
+// keep overwriting the same progress line.
+func terminal() bool {
+	info, err := os.Stderr.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// count is called for each path we walk during the pre-count; it
+// applies the same filters as check but only adds up files and bytes.
+func count(path string, info os.FileInfo, err error) error {
+	if interrupted() {
+		return errInterrupted
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if path != walkRoot && excluded(path, info) {
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+
+	if *follow && info.Mode()&os.ModeSymlink != 0 {
+		return followLink(path, count)
+	}
+
+	if info.IsDir() && tooDeep(path) {
+		return filepath.SkipDir
+	}
+
+	if info.IsDir() && *follow && !firstVisit(path, info) {
+		return filepath.SkipDir
+	}
+
+	if info.IsDir() && *skipSpecial {
+		if _, ok := specialFilesystem(path); ok {
+			return filepath.SkipDir
+		}
+	}
+
+	if ok, _ := candidate(path, info); !ok {
+		return nil
+	}
+
+	totalFiles++
+	totalBytes += bytesize(info.Size())
+	return nil
+}
+
+// showProgress accounts for another file with the given path and size
+// and updates the progress line, but only every so often. On a terminal
+// we keep overwriting the same line, otherwise we print a new line much
+// less often.
+func showProgress(path string, size bytesize) {
+	doneBytes += size
+	start, end, width, due := progressLine()
+	if !due {
+		return
+	}
+
+	hashedSoFar := bytesize(atomic.LoadInt64(&hashedBytes))
+	if !*precount {
+		fmt.Fprintf(os.Stderr, "%s%v files, %v hashed, %-*s%s", start, files, hashedSoFar, width, shortPath(path), end)
+		return
+	}
+
+	percent := 100.0
+	if totalBytes > 0 {
+		percent = 100.0 * float64(doneBytes) / float64(totalBytes)
+	}
+	fmt.Fprintf(os.Stderr, "%s%5.1f%% (%v of %v files, %v of %v, %v hashed)%s", start, percent, files, totalFiles, doneBytes, totalBytes, hashedSoFar, end)
+}
+
+// showHashing updates the progress line while we hash the files left
+// over after the walk, the given path being the next of the given total.
+func showHashing(path string, done, total counter) {
+	start, end, width, due := progressLine()
+	if !due {
+		return
+	}
+	hashedSoFar := bytesize(atomic.LoadInt64(&hashedBytes))
+	fmt.Fprintf(os.Stderr, "%shashing %v of %v files, %v hashed, %-*s%s", start, done, total, hashedSoFar, width, shortPath(path), end)
+}
+
+// progressLine checks whether it's time to update the progress line; if
+// so, it returns what to start and end the line with and how much room
+// there is for a path.
+func progressLine() (start, end string, width int, due bool) {
+	tty := terminal()
+	interval := progressInterval
+	if !tty {
+		interval = logInterval
+	}
+	if time.Since(lastShown) < interval {
+		return "", "", 0, false
+	}
+	lastShown = time.Now()
+
+	if !tty {
+		return "", "\n", 0, true
+	}
+	return "\r", "   ", pathWidth, true
+}
+
+// shortPath shortens the given path to fit into the progress line.
+func shortPath(path string) string {
+	if len(path) > pathWidth {
+		return "..." + path[len(path)-pathWidth+3:]
+	}
+	return path
+}
+
+// endProgress finishes the progress line, if we ever printed one.
+func endProgress() {
+	if lastShown.IsZero() {
+		return
+	}
+	lastShown = time.Time{}
+	showProgress("", 0)
+	if terminal() {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// resetProgress forgets everything about the previous progress.
+func resetProgress() {
+	totalFiles, totalBytes, doneBytes = 0, 0, 0
+	atomic.StoreInt64(&hashedBytes, 0)
+	lastShown = time.Time{}
+}
@@ -0,0 +1,135 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	dirFiles = make(map[string]int)   // maps from directories to number of files below them
+	dirBytes = make(map[string]int64) // maps from directories to space (in bytes) used below them
+)
+
+// countDir adds the file with the given path and size to the statistics
+// of all directories between it and the root we're walking.
+func countDir(path string, size int64) {
+	root := filepath.Clean(walkRoot)
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			break
+		}
+		dirFiles[dir]++
+		dirBytes[dir] += size
+		if dir == root || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+}
+
+// countSkipped counts the given path, which we skipped, as a file without
+// copies, so the directories above it are never redundant.
+func countSkipped(path string) {
+	if *redundant {
+		countDir(path, 0)
+	}
+}
+
+// under checks whether the given path is below the given directory; the
+// paths have to be spelled the same way for this to work.
+func under(path, dir string) bool {
+	return strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// redundantDirs finds directories all of whose files have a copy outside
+// of them. Removing one redundant directory can make another one (say
+// its mirror image) not redundant anymore, so we pick them one by one
+// and only count copies that aren't in a directory we already picked.
+// Directories below a picked directory aren't reported separately.
+func redundantDirs() []string {
+	clusters := make(map[string][]string) // maps from files to all files in their cluster
+	for _, c := range final {
+		members := c.members()
+		for _, m := range members {
+			clusters[m] = members
+		}
+	}
+
+	// a directory can only be redundant if all files below it are in a
+	// cluster; this rules out most directories cheaply
+	inClusters := make(map[string]int)
+	for m := range clusters {
+		for dir := filepath.Dir(m); dirFiles[dir] > 0; dir = filepath.Dir(dir) {
+			inClusters[dir]++
+			if dir == filepath.Dir(dir) {
+				break
+			}
+		}
+	}
+	var candidates []string
+	for dir, n := range inClusters {
+		if n == dirFiles[dir] {
+			candidates = append(candidates, dir)
+		}
+	}
+	sort.Strings(candidates)
+
+	var picked []string
+	outside := func(path, dir string) bool {
+		if path == dir || under(path, dir) {
+			return false
+		}
+		for _, p := range picked {
+			if under(path, p) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, dir := range candidates {
+		if len(picked) > 0 && under(dir, picked[len(picked)-1]) {
+			continue
+		}
+		redundant := true
+		for m, members := range clusters {
+			if !under(m, dir) {
+				continue
+			}
+			copied := false
+			for _, o := range members {
+				if outside(o, dir) {
+					copied = true
+					break
+				}
+			}
+			if !copied {
+				redundant = false
+				break
+			}
+		}
+		if redundant {
+			picked = append(picked, dir)
+		}
+	}
+	return picked
+}
+
+// printRedundantDirs prints the redundant directories and how much space
+// removing them would reclaim.
+func printRedundantDirs() {
+	var total bytesize
+	dirs := redundantDirs()
+	fmt.Fprintln(out, "# redundant directories")
+	for _, dir := range dirs {
+		fmt.Fprintf(out, "%s (%v)\n", dir, bytesize(dirBytes[dir]))
+		total += bytesize(dirBytes[dir])
+	}
+	fmt.Fprintf(out, "# %v redundant directories, %v reclaimable\n\n", counter(len(dirs)), total)
+}
@@ -0,0 +1,51 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// A directory with a unique file that -g filters out is not redundant,
+// removing it would lose that file.
+func TestRedundantDirsCountFilteredFiles(t *testing.T) {
+	capture(t)
+	dir := writeFiles(t, map[string]string{
+		"a_photos/x.jpg": "x", "a_photos/notes.txt": "unique",
+		"c_photos/y.jpg": "y",
+		"backup/x.jpg":   "x", "backup/y.jpg": "y",
+	})
+	if err := setFlags(t, map[string]string{"g": "*.jpg", "redundant-dirs": "true"}); err != nil {
+		t.Fatal(err)
+	}
+	reset()
+	run([]string{dir})
+
+	// without notes.txt, a_photos would come first and leave backup with
+	// a copy of y.jpg only
+	if got, want := redundantDirs(), []string{filepath.Join(dir, "backup")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got redundant directories %v, want %v", got, want)
+	}
+}
+
+// Neither is a directory with a subdirectory we didn't look at.
+func TestRedundantDirsCountSkippedDirs(t *testing.T) {
+	capture(t)
+	dir := writeFiles(t, map[string]string{
+		"a/x": "x", "a/skipped/z": "unique",
+		"b/x": "x",
+	})
+	if err := setFlags(t, map[string]string{"exclude": "skipped", "redundant-dirs": "true"}); err != nil {
+		t.Fatal(err)
+	}
+	reset()
+	run([]string{dir})
+
+	if got, want := redundantDirs(), []string{filepath.Join(dir, "b")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got redundant directories %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// errNotRegular is what openRegular returns for anything but a regular
+// file.
+var errNotRegular = errors.New("not a regular file")
+
+// openRegular opens the file with the given path for reading, but only if
+// it's (still) a regular file. The walk already skips everything else, but
+// a file can be replaced between the walk and the time we read it, and
+// just opening a named pipe would block until somebody writes to it; so
+// we open without blocking where we can and check again on the open file.
+func openRegular(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY|openNonblock, 0)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, fmt.Errorf("%w (%s)", errNotRegular, fileType(info.Mode()))
+	}
+	return file, nil
+}
@@ -0,0 +1,11 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+//go:build !unix
+
+package main
+
+// openNonblock is nothing special where named pipes don't live in the
+// filesystem.
+const openNonblock = 0
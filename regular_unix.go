@@ -0,0 +1,13 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+//go:build unix
+
+package main
+
+import "syscall"
+
+// openNonblock keeps opening a named pipe from blocking; it makes no
+// difference for regular files.
+const openNonblock = syscall.O_NONBLOCK
@@ -0,0 +1,55 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Hashing a named pipe fails right away instead of waiting for somebody
+// to write to it, and a walk over a tree with one in it still finishes.
+func TestNamedPipeDoesntHang(t *testing.T) {
+	capture(t)
+	dir := writeFiles(t, map[string]string{"a": "same", "b": "same"})
+	fifo := filepath.Join(dir, "fifo")
+	if err := syscall.Mkfifo(fifo, 0666); err != nil {
+		t.Skip("no named pipes here:", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := checksum(fifo, newHash)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, errNotRegular) {
+			t.Errorf("hashing a named pipe returned %v, want %v", err, errNotRegular)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("hashing a named pipe hangs")
+	}
+
+	finished := make(chan bool)
+	go func() {
+		reset()
+		run([]string{dir})
+		finished <- true
+	}()
+	select {
+	case <-finished:
+		if files != 2 || dupes != 1 {
+			t.Errorf("got %v files and %v duplicates, want 2 and 1", files, dupes)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("walking a named pipe hangs")
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// exists checks whether there's still a file with the given path.
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// revalidate makes sure every file in every cluster still exists, since
+// something else may have removed files while we were scanning. Vanished
+// duplicates are dropped; if the original vanished, the first surviving
+// duplicate becomes the new original. Clusters with fewer than two
+// surviving files are dropped altogether.
+func revalidate() {
+	for _, k := range originals() {
+		c := final[k]
+		members := c.members()
+
+		var alive []string
+		for _, m := range members {
+			if exists(m) {
+				alive = append(alive, m)
+			} else {
+				fmt.Fprintf(errOut, "warning: %s vanished during the scan\n", m)
+			}
+		}
+		if len(alive) == len(members) {
+			continue
+		}
+
+		// forget the old cluster...
+		delete(final, k)
+		dupes -= counter(len(c.duplicates))
+		wasted -= c.waste()
+
+		// ...and record what's left of it
+		if len(alive) < 2 {
+			continue
+		}
+		c = &cluster{original: alive[0], duplicates: alive[1:], size: c.size, sum: c.sum}
+		final[c.original] = c
+		dupes += counter(len(c.duplicates))
+		wasted += c.waste()
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// If the original vanishes between the scan and the report, the first
+// surviving duplicate takes its place; if only one file survives, there's
+// no cluster left at all.
+func TestRevalidateVanishedOriginal(t *testing.T) {
+	_, warnings := capture(t)
+	dir := writeFiles(t, map[string]string{"a": "abc", "b": "abc", "c": "abc", "x": "xy", "y": "xy"})
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	reset()
+	final[path("a")] = &cluster{original: path("a"), duplicates: []string{path("b"), path("c")}, size: 3}
+	final[path("x")] = &cluster{original: path("x"), duplicates: []string{path("y")}, size: 2}
+	dupes, wasted = 3, 8
+	for _, name := range []string{"a", "x"} {
+		if err := os.Remove(path(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	revalidate()
+
+	c, ok := final[path("b")]
+	if !ok || len(final) != 1 {
+		t.Fatalf("got clusters %v, want one with original %s", originals(), path("b"))
+	}
+	if len(c.duplicates) != 1 || c.duplicates[0] != path("c") {
+		t.Errorf("got duplicates %v, want %s", c.duplicates, path("c"))
+	}
+	if dupes != 1 || wasted != 3 {
+		t.Errorf("got %v duplicates wasting %v, want 1 wasting 3 bytes", dupes, wasted)
+	}
+	if warnings.Len() == 0 {
+		t.Error("no warning about the vanished files")
+	}
+}
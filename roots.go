@@ -0,0 +1,193 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// unlimited is the depth of roots without a depth limit.
+const unlimited = -1
+
+var (
+	walkRoot  string // the root we're walking right now
+	walkDepth int    // how deep we may go below it
+
+	rootFiles []counter // number of files examined below each root, in order
+)
+
+// parseRoots splits the given arguments into root paths and their depth
+// limits. An argument like "photos=5" means we won't descend more than 5
+// directories below "photos"; if there's an actual file or directory by
+// the name "photos=5" however, that's what we'll walk.
+func parseRoots(args []string) (roots []string, depths []int) {
+	for _, arg := range args {
+		root, depth := arg, unlimited
+		if i := strings.LastIndex(arg, "="); i > 0 {
+			if d, err := strconv.Atoi(arg[i+1:]); err == nil && d >= 0 {
+				if _, err := os.Lstat(arg); os.IsNotExist(err) {
+					root, depth = arg[:i], d
+				}
+			}
+		}
+		roots = append(roots, absolute(root))
+		depths = append(depths, depth)
+	}
+	return roots, depths
+}
+
+// absolute returns the given path as is or, with -abs, as an absolute
+// path. Making roots absolute before the walk makes every path we find
+// (and print) absolute.
+func absolute(path string) string {
+	if !*absPaths {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
+
+// tooDeep checks whether the directory with the given path is deeper
+// below the root we're walking than we may go.
+func tooDeep(path string) bool {
+	if walkDepth == unlimited {
+		return false
+	}
+	rel, err := filepath.Rel(walkRoot, path)
+	if err != nil || rel == "." {
+		return false
+	}
+	return strings.Count(rel, string(filepath.Separator))+1 > walkDepth
+}
+
+// rootOf finds the index of the root the file with the given path was
+// found under, or -1 if it wasn't found under any of them. Roots are
+// walked in order and nothing is examined twice, so if roots are nested
+// the first one that contains the file is where we found it.
+func rootOf(path string, roots []string) int {
+	for i, root := range roots {
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+// display returns the given path the way we print it in clusters: as is
+// or, with -relative, relative to the root it was found under. With more
+// than one root, that root comes first in brackets so paths stay
+// unambiguous.
+func display(path string, roots []string) string {
+	if !*relative {
+		return path
+	}
+	i := rootOf(path, roots)
+	if i < 0 {
+		return path
+	}
+	rel, err := filepath.Rel(roots[i], path)
+	if err != nil {
+		return path
+	}
+	if rel == "." {
+		rel = filepath.Base(path) // the root is a file
+	}
+	if len(roots) == 1 {
+		return rel
+	}
+	return "[" + roots[i] + "] " + rel
+}
+
+// printRootStats prints the statistics for each of the given roots. A
+// duplicate's wasted space counts for the root it is in, not for the root
+// its original is in, so the numbers add up to the totals; duplicates of
+// an original in another root are pointed out separately.
+func printRootStats(roots []string) {
+	dupes := make([]counter, len(roots))
+	wasted := make([]bytesize, len(roots))
+	across := make([]counter, len(roots))
+	acrossWasted := make([]bytesize, len(roots))
+	for k, c := range final {
+		o := rootOf(k, roots)
+		for _, d := range c.duplicates {
+			i := rootOf(d, roots)
+			if i < 0 {
+				continue
+			}
+			dupes[i]++
+			wasted[i] += bytesize(c.size)
+			if i != o {
+				across[i]++
+				acrossWasted[i] += bytesize(c.size)
+			}
+		}
+	}
+
+	fmt.Fprintln(out, "# per root")
+	for i, root := range roots {
+		fmt.Fprintf(out, "%s: %v files examined, %v duplicates found, %v wasted", root, rootFiles[i], dupes[i], wasted[i])
+		if across[i] > 0 {
+			fmt.Fprintf(out, " (%v of them copy files in other roots, %v)", across[i], acrossWasted[i])
+		}
+		fmt.Fprintln(out)
+	}
+	fmt.Fprintln(out)
+}
+
+// checkPaths examines the files listed in the given reader, one per line
+// or, with -0, each terminated by a NUL byte. Each path is its own root,
+// and we don't walk directories; the list presumably came from a tool like
+// find that already did.
+func checkPaths(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	if *nulOut {
+		scanner.Split(scanNul)
+	}
+	for scanner.Scan() && !interrupted() {
+		path := scanner.Text()
+		if path == "" {
+			continue
+		}
+		path = absolute(path)
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(errOut, "warning: skipping %s (%v)\n", path, err)
+			continue
+		}
+		if info.IsDir() {
+			verbosef("skipping %s (directory)", path)
+			continue
+		}
+		walkRoot, walkDepth = path, unlimited
+		if err := check(path, info, nil); err != nil {
+			fmt.Fprintf(errOut, "warning: skipping %s (%v)\n", path, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// scanNul is a split function for bufio.Scanner that returns each
+// NUL-terminated token.
+func scanNul(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
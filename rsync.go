@@ -0,0 +1,78 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rsyncRule turns the given duplicate into an rsync exclude rule anchored
+// at the root it was found under, so rsync only skips that one file and
+// not all files of the same name.
+func rsyncRule(path string, roots []string) (string, bool) {
+	var rel string
+	for _, root := range roots {
+		if !isUnder(path, root) {
+			continue
+		}
+		info, err := os.Stat(root)
+		if err == nil && !info.IsDir() {
+			root = filepath.Dir(root)
+		}
+		r, err := filepath.Rel(root, path)
+		if err == nil {
+			rel = r
+			break
+		}
+	}
+	if rel == "" || strings.ContainsAny(rel, "\n\r") {
+		return "", false
+	}
+
+	rel = filepath.ToSlash(rel)
+	// rsync only honors backslash escapes in patterns with wildcards
+	if strings.ContainsAny(rel, "*?[") {
+		var b strings.Builder
+		for _, c := range rel {
+			if strings.ContainsRune(`\*?[`, c) {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(c)
+		}
+		rel = b.String()
+	}
+	return "- /" + rel, true
+}
+
+// writeRsyncExcludes writes an rsync exclude rule for every duplicate (but
+// not the originals) to the file with the given path.
+func writeRsyncExcludes(path string, roots []string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+
+	for _, k := range sortedDupes() {
+		for _, d := range final[k].duplicates {
+			rule, ok := rsyncRule(d, roots)
+			if !ok {
+				fmt.Fprintf(errOut, "warning: can't write rsync rule for %q\n", d)
+				continue
+			}
+			fmt.Fprintln(w, rule)
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,41 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+//go:build darwin || freebsd || dragonfly
+
+package main
+
+import "syscall"
+
+// detectsSpecial says whether specialFilesystem works here.
+const detectsSpecial = true
+
+// specialFilesystems lists the names statfs(2) reports for
+// pseudo-filesystems on macOS and the BSDs.
+var specialFilesystems = map[string]bool{
+	"devfs":     true,
+	"fdesc":     true,
+	"fdescfs":   true,
+	"procfs":    true,
+	"linprocfs": true,
+	"linsysfs":  true,
+	"mqueuefs":  true,
+}
+
+// specialFilesystem checks whether the directory with the given path lives
+// on a pseudo-filesystem; if so it also returns the name of that filesystem.
+func specialFilesystem(path string) (string, bool) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return "", false
+	}
+	var name []byte
+	for _, c := range fs.Fstypename {
+		if c == 0 {
+			break
+		}
+		name = append(name, byte(c))
+	}
+	return string(name), specialFilesystems[string(name)]
+}
@@ -0,0 +1,40 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import "syscall"
+
+// detectsSpecial says whether specialFilesystem works here.
+const detectsSpecial = true
+
+// specialFilesystems maps statfs magic numbers of pseudo-filesystems to
+// their names; see statfs(2) and linux/magic.h for the numbers.
+var specialFilesystems = map[uint32]string{
+	0x9fa0:     "proc",
+	0x62656572: "sysfs",
+	0x1cd1:     "devpts",
+	0x64626720: "debugfs",
+	0x74726163: "tracefs",
+	0x73636673: "securityfs",
+	0x27e0eb:   "cgroup",
+	0x63677270: "cgroup2",
+	0x6165676c: "pstore",
+	0xcafe4a11: "bpf",
+	0x62656570: "configfs",
+	0x65735543: "fusectl",
+	0x19800202: "mqueue",
+	0x42494e4d: "binfmt_misc",
+}
+
+// specialFilesystem checks whether the directory with the given path lives
+// on a pseudo-filesystem; if so it also returns the name of that filesystem.
+func specialFilesystem(path string) (string, bool) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return "", false
+	}
+	name, ok := specialFilesystems[uint32(fs.Type)]
+	return name, ok
+}
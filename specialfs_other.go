@@ -0,0 +1,16 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+//go:build !linux && !darwin && !freebsd && !dragonfly
+
+package main
+
+// detectsSpecial says whether specialFilesystem works here.
+const detectsSpecial = false
+
+// specialFilesystem always says no; we only know how to detect
+// pseudo-filesystems on Linux, macOS, FreeBSD, and DragonFly.
+func specialFilesystem(path string) (string, bool) {
+	return "", false
+}
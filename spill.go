@@ -0,0 +1,104 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// spillBuckets is the number of temporary files we spread records over;
+// only one of them has to fit into memory at a time.
+const spillBuckets = 64
+
+// spill is the store for the current scan if we're spilling to disk.
+var spill *spillStore
+
+// spillStore partitions (size, path) records over a number of temporary
+// files by size, so all files of the same size end up in the same bucket.
+type spillStore struct {
+	dir     string
+	files   []*os.File
+	writers []*bufio.Writer
+}
+
+// newSpillStore creates the temporary files for a new store.
+func newSpillStore() (*spillStore, error) {
+	dir, err := os.MkdirTemp("", "dupes-spill-")
+	if err != nil {
+		return nil, err
+	}
+	s := &spillStore{dir: dir}
+	for i := 0; i < spillBuckets; i++ {
+		f, err := os.Create(filepath.Join(dir, strconv.Itoa(i)))
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		s.files = append(s.files, f)
+		s.writers = append(s.writers, bufio.NewWriter(f))
+	}
+	return s, nil
+}
+
+// add records a file with the given size and path; records are terminated
+// by NUL since that's the one byte that can't be in a path.
+func (s *spillStore) add(size int64, path string) error {
+	_, err := fmt.Fprintf(s.writers[size%spillBuckets], "%d\t%s\x00", size, path)
+	return err
+}
+
+// collate goes through the buckets one by one and records duplicates the
+// same way check does: within each size, the first file with a given
+// digest is the original and later ones are its duplicates.
+func (s *spillStore) collate() error {
+	for i, f := range s.files {
+		if err := s.writers[i].Flush(); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		bySize := make(map[int64][]*fileEntry)
+		r := bufio.NewReader(f)
+		for {
+			record, err := r.ReadString('\x00')
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			fields := strings.SplitN(strings.TrimSuffix(record, "\x00"), "\t", 2)
+			size, err := strconv.ParseInt(fields[0], 10, 64)
+			if err != nil || len(fields) != 2 {
+				return fmt.Errorf("corrupt record %q", record)
+			}
+			bySize[size] = append(bySize[size], &fileEntry{path: fields[1], size: size})
+		}
+
+		for size, es := range bySize {
+			if len(es) < 2 {
+				delete(bySize, size)
+			}
+		}
+		collateBuckets(bySize)
+	}
+	return nil
+}
+
+// close removes the temporary files.
+func (s *spillStore) close() {
+	for _, f := range s.files {
+		f.Close()
+	}
+	os.RemoveAll(s.dir)
+}
@@ -0,0 +1,64 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// snapshot returns the clusters found by the last scan, duplicates in
+// order, along with the totals.
+func snapshot() (clusters map[string][]string, total counter, waste bytesize) {
+	clusters = make(map[string][]string)
+	for _, k := range sortedDupes() {
+		clusters[k] = final[k].duplicates
+	}
+	return clusters, dupes, wasted
+}
+
+// -spill must find exactly what the in-memory maps find, even with more
+// sizes than it has buckets.
+func TestSpillMatchesMemory(t *testing.T) {
+	_, warnings := capture(t)
+	tree := make(map[string]string)
+	for i := 0; i < 300; i++ {
+		// sizes from 1 to 100, three files each, every third file
+		// the same as one of the others
+		size := i%100 + 1
+		contents := strings.Repeat(string(rune('a'+i%3)), size)
+		if i%3 == 2 {
+			contents = strings.Repeat("a", size)
+		}
+		tree[fmt.Sprintf("d%d/f%d", i%7, i)] = contents
+	}
+	dir := writeFiles(t, tree)
+
+	reset()
+	run([]string{dir})
+	want, wantDupes, wantWasted := snapshot()
+	if wantDupes == 0 {
+		t.Fatal("no duplicates to compare")
+	}
+
+	if err := setFlags(t, map[string]string{"spill": "true"}); err != nil {
+		t.Fatal(err)
+	}
+	reset()
+	run([]string{dir})
+	got, gotDupes, gotWasted := snapshot()
+	if warnings.Len() > 0 {
+		t.Fatalf("got warnings %q", warnings)
+	}
+
+	if gotDupes != wantDupes || gotWasted != wantWasted {
+		t.Errorf("got %v duplicates wasting %v, want %v wasting %v", gotDupes, gotWasted, wantDupes, wantWasted)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got clusters %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// stat is a single labeled number for the statistics table.
+type stat struct {
+	label string
+	value fmt.Stringer
+}
+
+// throughput returns the bytes hashed so far and how many of them we
+// hashed per second if that took the given time.
+func throughput(runtime time.Duration) (hashed, rate bytesize) {
+	hashed = bytesize(atomic.LoadInt64(&hashedBytes))
+	if secs := runtime.Seconds(); secs > 0 {
+		rate = bytesize(float64(hashed) / secs)
+	}
+	return hashed, rate
+}
+
+// wastedAtLeast adds up the space wasted in clusters with at least the
+// given number of files, original included.
+func wastedAtLeast(min int) bytesize {
+	var total bytesize
+	for _, c := range final {
+		if len(c.duplicates)+1 >= min {
+			total += c.waste()
+		}
+	}
+	return total
+}
+
+// printStats prints the statistics for the scan we just did, which took
+// the given time, either as a sentence or as a table.
+func printStats(runtime time.Duration) {
+	hashed, rate := throughput(runtime)
+	if *tableStats {
+		stats := []stat{
+			{"files examined", files},
+			{"duplicates found", dupes},
+			{"wasted", wasted},
+		}
+		if *minCount > 0 {
+			label := fmt.Sprintf("wasted in %d+ copies", *minCount)
+			stats = append(stats, stat{label, wastedAtLeast(*minCount)})
+		}
+		if *skipLinked || linked > 0 {
+			stats = append(stats, stat{"hard links skipped", linked})
+		}
+		if denied > 0 {
+			stats = append(stats, stat{"permission denied", denied})
+		}
+		if *includeZero {
+			stats = append(stats, stat{"empty files", emptyFiles})
+		}
+		stats = append(stats,
+			stat{"runtime", stringer(formatDuration(runtime))},
+			stat{"hashed", hashed},
+			stat{"hashed per second", stringer(rate.String() + "/s")},
+		)
+		if *chunking {
+			stats = append(stats,
+				stat{"chunks examined", chunkCount},
+				stat{"chunks shared", chunkShared},
+				stat{"wasted at chunk level", chunkSavings},
+			)
+		}
+		printTable(stats)
+		return
+	}
+
+	fmt.Fprintf(out, "%v files examined, %v duplicates found, %v wasted", files, dupes, wasted)
+	if *minCount > 0 {
+		fmt.Fprintf(out, " (%v in clusters of %d or more copies)", wastedAtLeast(*minCount), *minCount)
+	}
+	if *skipLinked || linked > 0 {
+		fmt.Fprintf(out, ", %v hard links skipped", linked)
+	}
+	if denied > 0 {
+		fmt.Fprintf(out, ", %v skipped (permission denied)", denied)
+	}
+	if *includeZero {
+		fmt.Fprintf(out, ", %v empty", emptyFiles)
+	}
+	fmt.Fprintf(out, ", took %s (%v hashed at %v/s)\n", formatDuration(runtime), hashed, rate)
+	if *chunking {
+		fmt.Fprintf(out, "%v chunks examined, %v shared, %v wasted at chunk level (experimental)\n", chunkCount, chunkShared, chunkSavings)
+	}
+}
+
+// stringer is a string that can go into the statistics table.
+type stringer string
+
+func (s stringer) String() string { return string(s) }
+
+// printTable prints the given statistics as two aligned columns, labels
+// on the left and values on the right.
+func printTable(stats []stat) {
+	lw, vw := 0, 0
+	for _, s := range stats {
+		if n := utf8.RuneCountInString(s.label); n > lw {
+			lw = n
+		}
+		if n := utf8.RuneCountInString(s.value.String()); n > vw {
+			vw = n
+		}
+	}
+	for _, s := range stats {
+		fmt.Fprintf(out, "%-*s  %*s\n", lw, s.label, vw, s.value)
+	}
+}
+
+// printStatsJSON prints the statistics for the scan we just did, which
+// took the given time, as a JSON object; numbers are raw integers.
+func printStatsJSON(runtime time.Duration) error {
+	clusters := uint64(len(final))
+	if *countOnly {
+		clusters = uint64(len(counted))
+	}
+	stats := struct {
+		FilesExamined    uint64 `json:"filesExamined"`
+		DuplicatesFound  uint64 `json:"duplicatesFound"`
+		BytesWasted      uint64 `json:"bytesWasted"`
+		Clusters         uint64 `json:"clusters"`
+		UniqueFiles      uint64 `json:"uniqueFiles"`
+		BytesScanned     uint64 `json:"bytesScanned"`
+		RuntimeMillis    int64  `json:"runtimeMillis"`
+		HardLinksSkipped uint64 `json:"hardLinksSkipped,omitempty"`
+		PermissionDenied uint64 `json:"permissionDenied,omitempty"`
+		BytesHashed      uint64 `json:"bytesHashed"`
+		EmptyFiles       uint64 `json:"emptyFiles,omitempty"`
+		BytesWastedMin   uint64 `json:"bytesWastedMinCount,omitempty"`
+	}{
+		FilesExamined:    uint64(files),
+		DuplicatesFound:  uint64(dupes),
+		BytesWasted:      uint64(wasted),
+		Clusters:         clusters,
+		UniqueFiles:      uint64(files) - uint64(linked) - uint64(dupes) - clusters,
+		BytesScanned:     uint64(scanned),
+		RuntimeMillis:    runtime.Milliseconds(),
+		HardLinksSkipped: uint64(linked),
+		PermissionDenied: uint64(denied),
+		BytesHashed:      uint64(atomic.LoadInt64(&hashedBytes)),
+		EmptyFiles:       uint64(emptyFiles),
+	}
+	if *minCount > 0 {
+		stats.BytesWastedMin = uint64(wastedAtLeast(*minCount))
+	}
+	return json.NewEncoder(out).Encode(stats)
+}
@@ -0,0 +1,69 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// streamSizes holds the sizes whose first file we already hashed (only
+// for -stream).
+var streamSizes = make(map[int64]bool)
+
+// streamKey is the key we look up the file with the given path, digest,
+// and size under in hashes with -stream; unlike with the hash pool all
+// sizes share one map, so the size has to be part of it.
+func streamKey(path, sum string, size int64) string {
+	return collateKey(path, fmt.Sprintf("%s/%d", sum, size))
+}
+
+// streamHash calculates the digest of the file with the given path right
+// away; if that fails we warn and go on, just like collateBuckets does.
+func streamHash(path string) (string, bool) {
+	sum, err := digest(path)
+	if err != nil {
+		fmt.Fprintf(errOut, "warning: can't hash %s (%v)\n", path, err)
+		if errors.Is(err, fs.ErrPermission) {
+			denied++
+		}
+		return "", false
+	}
+	return sum, true
+}
+
+// streamDupe is what check does with -stream once the file with the given
+// path and size collides in size with first: instead of handing both to
+// the hash pool, we hash them right away and print the file as soon as we
+// know it's a duplicate.
+func streamDupe(path string, size int64, first string) {
+	if !streamSizes[size] {
+		streamSizes[size] = true
+		if sum, ok := streamHash(first); ok {
+			hashes[streamKey(first, sum, size)] = first
+		}
+	}
+
+	sum, ok := streamHash(path)
+	if !ok {
+		return
+	}
+	key := streamKey(path, sum, size)
+	original, ok := hashes[key]
+	if !ok {
+		hashes[key] = path
+		return
+	}
+
+	before := dupes
+	if err := recordDupe(path, original, sum, size); err != nil {
+		fmt.Fprintf(errOut, "warning: can't compare %s (%v)\n", path, err)
+		return
+	}
+	if dupes > before {
+		fmt.Fprintf(out, "%s\t%s\n", original, path)
+	}
+}
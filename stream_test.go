@@ -0,0 +1,48 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// -stream prints a line per duplicate as it goes, in walk order, and
+// doesn't keep any clusters.
+func TestStream(t *testing.T) {
+	results, _ := capture(t)
+	dir := writeFiles(t, map[string]string{"a": "same", "b": "other", "c": "same", "d": "same"})
+	if err := setFlags(t, map[string]string{"stream": "true", "no-stats": "true"}); err != nil {
+		t.Fatal(err)
+	}
+	reset()
+	run([]string{dir})
+
+	path := func(name string) string { return filepath.Join(dir, name) }
+	want := path("a") + "\t" + path("c") + "\n" + path("a") + "\t" + path("d") + "\n"
+	if got := strings.TrimSpace(results.String()) + "\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if dupes != 2 || len(final) != 0 {
+		t.Errorf("got %v duplicates and %d clusters, want 2 and none", dupes, len(final))
+	}
+}
+
+// Options that need the clusters, or a stdout of their own, don't go with
+// -stream.
+func TestStreamRejects(t *testing.T) {
+	for _, name := range []string{"stats-json", "json", "redundant-dirs", "sort"} {
+		value := "true"
+		if name == "sort" {
+			value = "size"
+		}
+		t.Run(name, func(t *testing.T) {
+			if err := setFlags(t, map[string]string{"stream": "true", name: value}); err == nil {
+				t.Errorf("-stream -%s accepted", name)
+			}
+		})
+	}
+}
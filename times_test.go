@@ -0,0 +1,42 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A time and a reference file on the same side compose: the stricter
+// bound wins.
+func TestTimeBoundsCompose(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"ref": ""})
+	ref := filepath.Join(dir, "ref")
+	refTime := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(ref, refTime, refTime); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		options      map[string]string
+		newer, older time.Time
+	}{
+		{map[string]string{"newer-than-file": ref, "newer-than": "2019-01-01T00:00:00Z"}, refTime, time.Time{}},
+		{map[string]string{"newer-than-file": ref, "newer-than": "2021-01-01T00:00:00Z"}, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+		{map[string]string{"older-than-file": ref, "older-than": "2019-01-01T00:00:00Z"}, time.Time{}, time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{map[string]string{"older-than-file": ref, "older-than": "2021-01-01T00:00:00Z"}, time.Time{}, refTime},
+	} {
+		t.Run("", func(t *testing.T) {
+			if err := setFlags(t, tc.options); err != nil {
+				t.Fatal(err)
+			}
+			if !newerThan.Equal(tc.newer) || !olderThan.Equal(tc.older) {
+				t.Errorf("%v: got window %v to %v, want %v to %v", tc.options, newerThan, olderThan, tc.newer, tc.older)
+			}
+		})
+	}
+}
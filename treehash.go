@@ -0,0 +1,52 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// treeHash calculates a digest for the tree with the given root. Each
+// regular file contributes its path relative to the root and its checksum;
+// we sort those pairs before combining them so two trees with the same
+// structure and contents get the same digest regardless of walk order.
+func treeHash(root string) (string, error) {
+	var entries []string
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		sum, err := checksum(path, newHash)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, filepath.ToSlash(rel)+"\x00"+sum)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sort.Strings(entries)
+
+	hasher := newHash()
+	for _, e := range entries {
+		io.WriteString(hasher, e)
+		io.WriteString(hasher, "\n")
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// fileCategories maps the names -types accepts to the extensions of the
+// files in that category.
+var fileCategories = map[string][]string{
+	"image":    {"jpg", "jpeg", "png", "gif", "bmp", "tif", "tiff", "webp", "heic", "heif", "raw", "cr2", "nef", "arw", "dng", "svg"},
+	"video":    {"mp4", "m4v", "mkv", "mov", "avi", "wmv", "flv", "webm", "mpg", "mpeg", "3gp", "ts"},
+	"audio":    {"mp3", "m4a", "aac", "flac", "ogg", "opus", "wav", "wma", "aiff", "alac"},
+	"document": {"pdf", "doc", "docx", "odt", "rtf", "txt", "md", "xls", "xlsx", "ods", "ppt", "pptx", "odp", "epub"},
+	"archive":  {"zip", "tar", "gz", "tgz", "bz2", "xz", "7z", "rar", "zst", "iso", "dmg"},
+}
+
+// typeExts holds the extensions of all categories selected with -types,
+// lowercased and without the dot (nil means any file).
+var typeExts map[string]bool
+
+// categoryNames lists the names -types accepts, sorted.
+func categoryNames() string {
+	var names []string
+	for name := range fileCategories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// selectTypes sets up typeExts for the given comma-separated list of
+// categories; an empty list selects everything.
+func selectTypes(list string) error {
+	typeExts = nil
+	if list == "" {
+		return nil
+	}
+	typeExts = make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		exts, ok := fileCategories[strings.TrimSpace(name)]
+		if !ok {
+			return fmt.Errorf("unknown file category %q for -types (try %s)", name, categoryNames())
+		}
+		for _, ext := range exts {
+			typeExts[ext] = true
+		}
+	}
+	return nil
+}
+
+// typeMatch checks whether the file with the given name is in one of the
+// categories selected with -types.
+func typeMatch(name string) bool {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+	return typeExts[ext]
+}
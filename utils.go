@@ -7,24 +7,42 @@ package main
 import (
 	"fmt"
 	"strings"
+	"time"
+)
+
+var (
+	binaryUnits  = []string{"bytes", "KB", "MB", "GB", "TB", "PB", "EB", "ZB", "YB"} // for powers of 1024
+	decimalUnits = []string{"bytes", "kB", "MB", "GB", "TB", "PB", "EB", "ZB", "YB"} // for powers of 1000 (SI)
 )
 
 // formatSizeWithUnit formats the given uint (which represents a size as
 // in "number of bytes") with suitable units (KB, MB, .., YB) to keep the
-// number itself small-ish.
-func formatSizeWithUnit(size uint64) string {
-	units := []string{"bytes", "KB", "MB", "GB", "TB", "PB", "EB", "ZB", "YB"}
+// number itself small-ish; each unit is base times the one before.
+// Nothing at all is just "0 bytes".
+func formatSizeWithUnit(size uint64, base float64, units []string) string {
+	if size == 0 {
+		return "0 " + units[0]
+	}
 	value := float64(size)
 
 	u := 0
-	for value > 1024.0 && u < len(units)-1 {
-		value /= 1024.0
+	for value >= base && u < len(units)-1 {
+		value /= base
 		u++
 	}
 
 	return fmt.Sprintf("%.2f %s", value, units[u])
 }
 
+// formatDuration formats the given duration compactly, as in "1m23s";
+// only durations under a second get milliseconds.
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
+
 // formatCountWithThousands formats the given uint with commas as
 // "thousands separators" to make it easier to read.
 func formatCountWithThousands(count uint64) string {
@@ -34,8 +52,13 @@ func formatCountWithThousands(count uint64) string {
 	return str
 }
 
-// split string s into chunks of at most n characters from the back.
+// split string s into chunks of at most n characters from the back; if
+// n isn't positive, s is the only chunk.
 func splitFromBack(s string, n int) []string {
+	if n <= 0 {
+		return []string{s}
+	}
+
 	var chunks []string
 
 	fullChunks := len(s) / n
@@ -57,9 +80,12 @@ func splitFromBack(s string, n int) []string {
 // just so we can attach a String method
 type bytesize uint64
 
-// String formats with formatSizeWithUnit.
+// String formats with formatSizeWithUnit, in powers of 1000 with -si.
 func (b bytesize) String() string {
-	return formatSizeWithUnit(uint64(b))
+	if *siSizes {
+		return formatSizeWithUnit(uint64(b), 1000.0, decimalUnits)
+	}
+	return formatSizeWithUnit(uint64(b), 1024.0, binaryUnits)
 }
 
 // just so we can attach a String method
@@ -0,0 +1,104 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Sizes switch units at powers of 1024, or of 1000 with -si.
+func TestBytesizeString(t *testing.T) {
+	for _, tc := range []struct {
+		size    bytesize
+		binary  string
+		decimal string
+	}{
+		{999, "999.00 bytes", "999.00 bytes"},
+		{1000, "1000.00 bytes", "1.00 kB"},
+		{1023, "1023.00 bytes", "1.02 kB"},
+		{1024, "1.00 KB", "1.02 kB"},
+		{999999, "976.56 KB", "1000.00 kB"},
+		{1000000, "976.56 KB", "1.00 MB"},
+		{1 << 20, "1.00 MB", "1.05 MB"},
+		{1000000000, "953.67 MB", "1.00 GB"},
+		{1 << 30, "1.00 GB", "1.07 GB"},
+		{1 << 40, "1.00 TB", "1.10 TB"},
+	} {
+		for _, si := range []string{"false", "true"} {
+			if err := setFlags(t, map[string]string{"si": si}); err != nil {
+				t.Fatal(err)
+			}
+			want := tc.binary
+			if si == "true" {
+				want = tc.decimal
+			}
+			if got := tc.size.String(); got != want {
+				t.Errorf("%d bytes with -si=%s got %q, want %q", uint64(tc.size), si, got, want)
+			}
+		}
+	}
+}
+
+// Exact powers of 1024 are one of the next unit, not 1024 of this one.
+func TestFormatSizeWithUnitBoundaries(t *testing.T) {
+	for size, want := range map[uint64]string{
+		1024:       "1.00 KB",
+		1048576:    "1.00 MB",
+		1073741824: "1.00 GB",
+	} {
+		if got := formatSizeWithUnit(size, 1024, binaryUnits); got != want {
+			t.Errorf("%d bytes got %q, want %q", size, got, want)
+		}
+		if got := formatSizeWithUnit(size-1, 1024, binaryUnits); got == want {
+			t.Errorf("%d bytes got %q too", size-1, got)
+		}
+	}
+}
+
+// Nothing at all is "0 bytes", in either mode.
+func TestFormatSizeWithUnitZero(t *testing.T) {
+	if got := formatSizeWithUnit(0, 1024, binaryUnits); got != "0 bytes" {
+		t.Errorf("got %q, want %q", got, "0 bytes")
+	}
+	if got := formatSizeWithUnit(0, 1000, decimalUnits); got != "0 bytes" {
+		t.Errorf("with -si got %q, want %q", got, "0 bytes")
+	}
+}
+
+func TestFormatCountWithThousands(t *testing.T) {
+	for count, want := range map[uint64]string{
+		0:       "0",
+		7:       "7",
+		999:     "999",
+		1000:    "1,000",
+		123456:  "123,456",
+		1234567: "1,234,567",
+	} {
+		if got := formatCountWithThousands(count); got != want {
+			t.Errorf("%d got %q, want %q", count, got, want)
+		}
+	}
+}
+
+// Chunks that aren't at least one character long make no sense; the
+// string stays in one piece.
+func TestSplitFromBack(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		n    int
+		want []string
+	}{
+		{"12345", 0, []string{"12345"}},
+		{"12345", -3, []string{"12345"}},
+		{"12345", 2, []string{"1", "23", "45"}},
+		{"123", 3, []string{"123"}},
+		{"", 3, nil},
+	} {
+		if got := splitFromBack(tc.s, tc.n); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("splitFromBack(%q, %d) got %q, want %q", tc.s, tc.n, got, tc.want)
+		}
+	}
+}
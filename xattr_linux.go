@@ -0,0 +1,53 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// xattrHash calculates a hash digest over the sorted extended attributes
+// (names and values) of the file with the given path. Files on filesystems
+// without extended attributes get an empty digest.
+func xattrHash(path string) (string, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err == syscall.ENOTSUP {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if size == 0 {
+		return "", nil
+	}
+	list := make([]byte, size)
+	size, err = syscall.Listxattr(path, list)
+	if err != nil {
+		return "", err
+	}
+
+	names := strings.Split(strings.TrimRight(string(list[:size]), "\x00"), "\x00")
+	sort.Strings(names)
+
+	hasher := sha1.New()
+	for _, name := range names {
+		size, err := syscall.Getxattr(path, name, nil)
+		if err != nil {
+			return "", err
+		}
+		value := make([]byte, size)
+		size, err = syscall.Getxattr(path, name, value)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hasher, "%s\x00%d\x00", name, size)
+		hasher.Write(value[:size])
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
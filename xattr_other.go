@@ -0,0 +1,13 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+//go:build !linux
+
+package main
+
+// xattrHash always returns an empty digest; we only know how to get at
+// extended attributes on Linux.
+func xattrHash(path string) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2016 Peter H. Froehlich. All rights reserved.
+// Use of this source code is governed by the MIT license,
+// see the LICENSE.md file.
+
+package main
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// This is XXH64 (with seed 0), a fast non-cryptographic hash; see
+// https://github.com/Cyan4973/xxHash for the specification. It's small
+// enough that we'd rather have it here than depend on a package.
+
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// xxhash is the state of an XXH64 calculation.
+type xxhash struct {
+	v     [4]uint64 // accumulators for 32-byte stripes
+	buf   [32]byte  // bytes that don't make up a whole stripe yet
+	n     int       // number of bytes in buf
+	total uint64    // number of bytes written so far
+}
+
+func newXXHash() hash.Hash64 {
+	x := &xxhash{}
+	x.Reset()
+	return x
+}
+
+func (x *xxhash) Reset() {
+	p1, p2 := xxPrime1, xxPrime2 // variables so the sums can wrap around
+	x.v = [4]uint64{p1 + p2, p2, 0, -p1}
+	x.n = 0
+	x.total = 0
+}
+
+func (x *xxhash) Size() int      { return 8 }
+func (x *xxhash) BlockSize() int { return 32 }
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMerge(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}
+
+// stripe mixes the given 32 bytes into the accumulators.
+func (x *xxhash) stripe(b []byte) {
+	x.v[0] = xxRound(x.v[0], binary.LittleEndian.Uint64(b[0:]))
+	x.v[1] = xxRound(x.v[1], binary.LittleEndian.Uint64(b[8:]))
+	x.v[2] = xxRound(x.v[2], binary.LittleEndian.Uint64(b[16:]))
+	x.v[3] = xxRound(x.v[3], binary.LittleEndian.Uint64(b[24:]))
+}
+
+func (x *xxhash) Write(p []byte) (int, error) {
+	n := len(p)
+	x.total += uint64(n)
+
+	if x.n > 0 {
+		c := copy(x.buf[x.n:], p)
+		x.n += c
+		p = p[c:]
+		if x.n < len(x.buf) {
+			return n, nil
+		}
+		x.stripe(x.buf[:])
+		x.n = 0
+	}
+	for len(p) >= 32 {
+		x.stripe(p[:32])
+		p = p[32:]
+	}
+	x.n = copy(x.buf[:], p)
+	return n, nil
+}
+
+func (x *xxhash) Sum64() uint64 {
+	var h uint64
+	if x.total >= 32 {
+		v := x.v
+		h = bits.RotateLeft64(v[0], 1) + bits.RotateLeft64(v[1], 7) +
+			bits.RotateLeft64(v[2], 12) + bits.RotateLeft64(v[3], 18)
+		for _, a := range v {
+			h = xxMerge(h, a)
+		}
+	} else {
+		h = xxPrime5
+	}
+	h += x.total
+
+	b := x.buf[:x.n]
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(b))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b)) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+func (x *xxhash) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, x.Sum64())
+}